@@ -0,0 +1,51 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"time"
+)
+
+// Clock is the interface that provides the current time and timers to the
+// limiters and meters. It can be replaced via LimiterConfig and MeterConfig,
+// mainly to make tests deterministic. See the fakeclock package for a Clock
+// that is advanced manually.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the interface of a timer created by a Clock. It behaves like
+// time.Timer. C returns the channel on which the time is delivered, and Stop
+// prevents the timer from firing.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// SystemClock is the Clock using the system time by the time package.
+var SystemClock Clock = systemClock{}
+
+// systemClock implements Clock using the time package.
+type systemClock struct{}
+
+// systemTimer implements Timer using time.Timer.
+type systemTimer struct {
+	t *time.Timer
+}
+
+// Now returns time.Now().
+func (systemClock) Now() time.Time { return time.Now() }
+
+// NewTimer returns a Timer using time.NewTimer.
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{t: time.NewTimer(d)}
+}
+
+// C returns the channel of the underlying time.Timer.
+func (t systemTimer) C() <-chan time.Time { return t.t.C }
+
+// Stop calls Stop of the underlying time.Timer.
+func (t systemTimer) Stop() bool { return t.t.Stop() }
@@ -0,0 +1,202 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// Conn implements both bit rate limiting and bit rate measurement for a
+// net.Conn object, independently for each direction. Reads are limited and
+// measured by a Reader, and writes are limited and measured by a Writer.
+//
+// The deadlines are passed through to the underlying connection, and also end
+// the wait for the limiter with an error wrapping os.ErrDeadlineExceeded, as
+// the underlying connection does.
+type Conn struct {
+	conn    net.Conn // underlying connection provided by the client
+	rd      *Reader
+	wr      *Writer
+	rdl     *deadline
+	wdl     *deadline
+	onClose func(*Conn)
+	closed  bool
+	mu      sync.Mutex
+}
+
+// ConnConfig indicates the configuration parameters of Conn for each
+// direction. If any of them is nil, the default configuration will be used.
+type ConnConfig struct {
+	ReadLimiter  *LimiterConfig
+	WriteLimiter *LimiterConfig
+	ReadMeter    *MeterConfig
+	WriteMeter   *MeterConfig
+}
+
+// NewConn creates a new Conn with default configurations. readRate and
+// writeRate are the limiting bit rates of reads and writes respectively.
+func NewConn(conn net.Conn, readRate, writeRate infounit.BitRate) (*Conn, error) {
+	return NewConnWithConfig(conn, readRate, writeRate, nil)
+}
+
+// NewConnWithConfig creates a new Conn with the specified configurations. If
+// conf is nil, the default configurations will be used.
+func NewConnWithConfig(conn net.Conn, readRate, writeRate infounit.BitRate, conf *ConnConfig) (*Conn, error) {
+	if conf == nil {
+		conf = &ConnConfig{}
+	}
+	rd, err := NewReaderWithConfig(conn, readRate, conf.ReadLimiter, conf.ReadMeter)
+	if err != nil {
+		return nil, err
+	}
+	wr, err := NewWriterWithConfig(conn, writeRate, conf.WriteLimiter, conf.WriteMeter)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, rd, wr), nil
+}
+
+// newConn creates a new Conn with the specified Reader and Writer, both of
+// which wrap conn.
+func newConn(conn net.Conn, rd *Reader, wr *Writer) *Conn {
+	return &Conn{
+		conn: conn,
+		rd:   rd,
+		wr:   wr,
+		rdl:  newDeadline(rd.lr.lim.clock),
+		wdl:  newDeadline(wr.lw.lim.clock),
+	}
+}
+
+// Read reads data from the connection. This may return shorter length than
+// len(p). It may block for up to maxWait time, or until the read deadline.
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.rd.ReadContext(c.rdl.context(), p)
+}
+
+// Write writes data to the connection. It blocks until all the data in p is
+// written, or until the write deadline.
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.wr.WriteContext(c.wdl.context(), p)
+}
+
+// Close closes the connection, and ends the bit rate measurement of both
+// directions.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.rdl.stop()
+	c.wdl.stop()
+	_ = c.rd.CloseSingle()
+	_ = c.wr.CloseSingle()
+	if c.onClose != nil {
+		c.onClose(c)
+	}
+	return c.conn.Close()
+}
+
+// CloseRead shuts down the reading side of the connection, and ends the bit
+// rate measurement of reads. It returns ErrNotSupported if the underlying
+// connection does not implement CloseRead, such as *net.TCPConn does.
+func (c *Conn) CloseRead() error {
+	cr, ok := c.conn.(interface{ CloseRead() error })
+	if !ok {
+		return ErrNotSupported
+	}
+	_ = c.rd.CloseSingle()
+	return cr.CloseRead()
+}
+
+// CloseWrite shuts down the writing side of the connection, and ends the bit
+// rate measurement of writes. It returns ErrNotSupported if the underlying
+// connection does not implement CloseWrite, such as *net.TCPConn does.
+func (c *Conn) CloseWrite() error {
+	cw, ok := c.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return ErrNotSupported
+	}
+	_ = c.wr.CloseSingle()
+	return cw.CloseWrite()
+}
+
+// LocalAddr returns the local network address of the underlying connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address of the underlying connection.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.rdl.set(t)
+	c.wdl.set(t)
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.rdl.set(t)
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.wdl.set(t)
+	return c.conn.SetWriteDeadline(t)
+}
+
+// ReadLimitingBitRate returns the current limiting bit rate of reads.
+func (c *Conn) ReadLimitingBitRate() infounit.BitRate {
+	return c.rd.LimitingBitRate()
+}
+
+// WriteLimitingBitRate returns the current limiting bit rate of writes.
+func (c *Conn) WriteLimitingBitRate() infounit.BitRate {
+	return c.wr.LimitingBitRate()
+}
+
+// SetReadBitRate sets a new limiting bit rate of reads.
+func (c *Conn) SetReadBitRate(rate infounit.BitRate) error {
+	return c.rd.SetBitRate(rate)
+}
+
+// SetWriteBitRate sets a new limiting bit rate of writes.
+func (c *Conn) SetWriteBitRate(rate infounit.BitRate) error {
+	return c.wr.SetBitRate(rate)
+}
+
+// ReadBitRate returns the bit rate of reads in the most recent sampling period.
+func (c *Conn) ReadBitRate() infounit.BitRate {
+	return c.rd.BitRate()
+}
+
+// WriteBitRate returns the bit rate of writes in the most recent sampling
+// period.
+func (c *Conn) WriteBitRate() infounit.BitRate {
+	return c.wr.BitRate()
+}
+
+// ReadTotal returns the data transfer amount, elapsed time, and bit rate of
+// reads in the entire period from start.
+func (c *Conn) ReadTotal() (infounit.ByteCount, time.Duration, infounit.BitRate) {
+	return c.rd.Total()
+}
+
+// WriteTotal returns the data transfer amount, elapsed time, and bit rate of
+// writes in the entire period from start.
+func (c *Conn) WriteTotal() (infounit.ByteCount, time.Duration, infounit.BitRate) {
+	return c.wr.Total()
+}
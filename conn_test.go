@@ -0,0 +1,155 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestConn_deadline(t *testing.T) {
+	t.Parallel()
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go func() { _, _ = io.Copy(ioutil.Discard, c2) }()
+
+	c, err := speedio.NewConn(c1, 8000, 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// 1000 bytes burst, and then the deadline expires while waiting.
+	if err := c.SetWriteDeadline(time.Now().Add(time.Millisecond * 200)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.Write(make([]byte, 2000))
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n != 1000 {
+		t.Errorf("unexpected len: want: 1000, got: %d", n)
+	}
+	if bc, _, _ := c.WriteTotal(); bc != 1000 {
+		t.Errorf("unexpected write total: %v", bc)
+	}
+
+	// clearing the deadline makes the conn usable again
+	if err := c.SetWriteDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(make([]byte, 100)); err != nil {
+		t.Error(err)
+	}
+
+	if err := c.CloseRead(); !errors.Is(err, speedio.ErrNotSupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// The deadlines are timed by the clock of the limiter.
+func TestConn_deadlineFakeClock(t *testing.T) {
+	t.Parallel()
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go func() { _, _ = io.Copy(ioutil.Discard, c2) }()
+
+	t0 := time.Now()
+	clk := fakeclock.New(t0)
+	conf := &speedio.ConnConfig{
+		WriteLimiter: &speedio.LimiterConfig{
+			Resolution: time.Second,
+			MaxWait:    time.Second,
+			Clock:      clk,
+		},
+	}
+	c, err := speedio.NewConnWithConfig(c1, 8000, 8000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.SetWriteDeadline(t0.Add(time.Millisecond * 500)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := c.Write(make([]byte, 2000))
+		done <- err
+	}()
+	clk.BlockUntil(2) // the deadline and the limiter
+	select {
+	case err := <-done:
+		t.Fatalf("write returned before the deadline: %v", err)
+	default:
+	}
+	clk.Advance(time.Millisecond * 500)
+	var nerr net.Error
+	if err := <-done; !errors.As(err, &nerr) || !nerr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+//
+func TestConn_tcp(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	received := make(chan int)
+	go func() {
+		sc, err := ln.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer sc.Close()
+		n, _ := io.Copy(ioutil.Discard, sc)
+		received <- int(n)
+	}()
+
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := speedio.NewConn(nc, 80000, 80000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.LocalAddr().String() != nc.LocalAddr().String() {
+		t.Errorf("unexpected local addr: %v", c.LocalAddr())
+	}
+	if _, err := c.Write(make([]byte, 5000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-received; n != 5000 {
+		t.Errorf("unexpected received len: want: 5000, got: %d", n)
+	}
+	if bc, _, _ := c.WriteTotal(); bc != 5000 {
+		t.Errorf("unexpected write total: %v", bc)
+	}
+	if got := c.WriteLimitingBitRate(); got != 80000 {
+		t.Errorf("unexpected limiting bit rate: %v", got)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// CopyOptions is a set of options for Copy.
+//
+// BitRate is the limiting bit rate. A zero bit rate means no limit.
+//
+// LimiterConfig and MeterConfig are the configurations used for the limiter
+// and the meter. If nil, the default configurations will be used.
+//
+// Progress, if not nil, is called with the statistics of the copy every
+// ProgressInterval, from a goroutine other than the one calling Copy. It is
+// not called if ProgressInterval is not positive. The interval is measured
+// with the clock of MeterConfig.
+//
+// Size is the expected number of bytes to be copied, used to estimate the
+// remaining time. A zero Size means unknown.
+type CopyOptions struct {
+	BitRate          infounit.BitRate
+	LimiterConfig    *LimiterConfig
+	MeterConfig      *MeterConfig
+	Progress         func(CopyStats)
+	ProgressInterval time.Duration
+	Size             infounit.ByteCount
+}
+
+// CopyStats is the statistics of a copy by Copy.
+//
+// Written is the number of bytes copied. Size is the expected number of bytes
+// specified in CopyOptions. Elapsed is the elapsed time since the first write.
+// BitRate is the current bit rate, and AverageBitRate is the average bit rate
+// since the first write. Remaining is the estimated remaining time, which is 0
+// if Size is unknown or the average bit rate is not yet available.
+type CopyStats struct {
+	Written        infounit.ByteCount
+	Size           infounit.ByteCount
+	Elapsed        time.Duration
+	BitRate        infounit.BitRate
+	AverageBitRate infounit.BitRate
+	Remaining      time.Duration
+}
+
+const (
+	defaultCopyBufferSize = 32 * 1024
+	minCopyBufferSize     = 512
+	maxCopyBufferSize     = 1024 * 1024
+)
+
+// Copy copies from src to dst until either EOF is reached on src or an error
+// occurs, limiting and measuring the transfer with the options. If opts is
+// nil, the transfer is only measured. It returns the final statistics even if
+// an error occurs. A successful Copy returns err == nil, not err == EOF.
+//
+// The size of the buffer is chosen so that each write to dst is the size
+// transferred at once by the limiter, not many tiny writes. If ctx is done,
+// Copy stops waiting for the limiter and returns ctx.Err(). The reads from src
+// are not interrupted. Neither src nor dst is closed.
+func Copy(ctx context.Context, dst io.Writer, src io.Reader, opts *CopyOptions) (*CopyStats, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	mw, err := NewMeterWriterWithConfig(dst, opts.MeterConfig)
+	if err != nil {
+		return nil, err
+	}
+	write := func(p []byte) (int, error) { return mw.Write(p) }
+	bufSize := defaultCopyBufferSize
+	var lw *LimiterWriter
+	if opts.BitRate != 0 {
+		lim, err := newLimiterWithConfig(opts.BitRate, opts.LimiterConfig)
+		if err != nil {
+			return nil, err
+		}
+		lw = newLimiterWriter(mw, lim)
+		write = func(p []byte) (int, error) { return lw.WriteContext(ctx, p) }
+		bufSize = lim.partialSize()
+		switch {
+		case bufSize < minCopyBufferSize:
+			bufSize = minCopyBufferSize
+		case maxCopyBufferSize < bufSize:
+			bufSize = maxCopyBufferSize
+		}
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	if opts.Progress != nil && 0 < opts.ProgressInterval {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				timer := mw.clock.NewTimer(opts.ProgressInterval)
+				select {
+				case <-timer.C():
+					opts.Progress(copyStats(mw, opts.Size))
+				case <-stop:
+					timer.Stop()
+					return
+				}
+			}
+		}()
+	}
+
+	err = copyBuffer(ctx, write, src, make([]byte, bufSize))
+
+	close(stop)
+	wg.Wait()
+	if lw != nil {
+		_ = lw.CloseSingle()
+	}
+	_ = mw.CloseSingle()
+	stats := copyStats(mw, opts.Size)
+	return &stats, err
+}
+
+// copyBuffer is the actual implementation of Copy.
+func copyBuffer(ctx context.Context, write func([]byte) (int, error), src io.Reader, buf []byte) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		nr, rerr := src.Read(buf)
+		if 0 < nr {
+			nw, werr := write(buf[:nr])
+			switch {
+			case werr != nil:
+				return werr
+			case nw != nr:
+				return io.ErrShortWrite
+			}
+		}
+		switch {
+		case errors.Is(rerr, io.EOF):
+			return nil
+		case rerr != nil:
+			return rerr
+		}
+	}
+}
+
+// copyStats returns the statistics of the meter.
+func copyStats(mw *MeterWriter, size infounit.ByteCount) CopyStats {
+	bc, et, avg := mw.Total()
+	stats := CopyStats{
+		Written:        bc,
+		Size:           size,
+		Elapsed:        et,
+		BitRate:        mw.BitRate(),
+		AverageBitRate: avg,
+	}
+	if bc < size && 0 < avg {
+		stats.Remaining = time.Duration(float64(size-bc) * 8 / float64(avg) * float64(time.Second))
+	}
+	return stats
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+	"github.com/tunabay/go-speedio"
+)
+
+// countWriter records the number and the maximum size of writes.
+type countWriter struct {
+	n, max, total int
+}
+
+// Write records the write and discards p.
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n++
+	if w.max < len(p) {
+		w.max = len(p)
+	}
+	w.total += len(p)
+	return len(p), nil
+}
+
+//
+func TestCopy_test1(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		progress []speedio.CopyStats
+	)
+	opts := &speedio.CopyOptions{
+		BitRate: 80000,
+		Progress: func(s speedio.CopyStats) {
+			mu.Lock()
+			progress = append(progress, s)
+			mu.Unlock()
+		},
+		ProgressInterval: time.Millisecond * 300,
+		Size:             20000,
+	}
+	dst := &countWriter{}
+
+	// 20000 bytes at 80 kbit/s: 10000 bytes burst and 10000 bytes in the
+	// next 1s, written 5000 bytes at once.
+	stats, err := speedio.Copy(context.Background(), dst, bytes.NewReader(make([]byte, 20000)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 20000 || dst.total != 20000 {
+		t.Errorf("unexpected written: want: 20000, got: %v, %d", stats.Written, dst.total)
+	}
+	if stats.Elapsed < time.Millisecond*900 {
+		t.Errorf("too fast: %s", stats.Elapsed)
+	}
+	if dst.max != 5000 || 4 < dst.n {
+		t.Errorf("unexpected writes: n=%d, max=%d", dst.n, dst.max)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(progress) < 2 {
+		t.Fatalf("too few progress reports: %d", len(progress))
+	}
+	for _, p := range progress {
+		if p.Size != 20000 || 20000 < p.Written {
+			t.Errorf("unexpected progress: %+v", p)
+		}
+		if p.Written < p.Size && 0 < p.AverageBitRate && p.Remaining == 0 {
+			t.Errorf("no remaining time: %+v", p)
+		}
+	}
+}
+
+//
+func TestCopy_cancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	opts := &speedio.CopyOptions{BitRate: 8000}
+	tc := time.Now()
+	stats, err := speedio.Copy(ctx, &countWriter{}, bytes.NewReader(make([]byte, 20000)), opts)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: want: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if et := time.Since(tc); time.Second < et {
+		t.Errorf("not cancelled: %s", et)
+	}
+	if stats == nil || stats.Written == 0 || 20000 <= stats.Written {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+//
+func TestCopy_unlimited(t *testing.T) {
+	t.Parallel()
+
+	dst := &countWriter{}
+	stats, err := speedio.Copy(context.Background(), dst, bytes.NewReader(make([]byte, 100000)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != infounit.ByteCount(100000) {
+		t.Errorf("unexpected written: want: 100000, got: %v", stats.Written)
+	}
+}
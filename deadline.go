@@ -0,0 +1,132 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// deadline is a deadline of read or write operations of a connection, which
+// is notified by closing a channel. The expiry is timed by the clock.
+type deadline struct {
+	clock  Clock
+	stopc  chan struct{} // closed to stop waiting for the timer, nil if not set
+	exited chan struct{} // closed when the goroutine waiting for the timer exits
+	cancel chan struct{} // closed when the deadline expires
+	mu     sync.Mutex
+}
+
+// newDeadline creates a deadline which is not set, timed by clock.
+func newDeadline(clock Clock) *deadline {
+	return &deadline{clock: clock, cancel: make(chan struct{})}
+}
+
+// set sets the deadline. A zero value for tc means no deadline.
+func (d *deadline) set(tc time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stopTimer()
+	closed := isClosedChan(d.cancel)
+	if tc.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := tc.Sub(d.clock.Now()); 0 < dur {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		timer := d.clock.NewTimer(dur)
+		cancel, stopc, exited := d.cancel, make(chan struct{}), make(chan struct{})
+		d.stopc, d.exited = stopc, exited
+		go func() {
+			defer close(exited)
+			select {
+			case <-timer.C():
+				close(cancel)
+			case <-stopc:
+				timer.Stop()
+			}
+		}()
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// stop stops the timer of the deadline, if any, without changing whether the
+// deadline has expired. It is called when the connection is closed.
+func (d *deadline) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopTimer()
+}
+
+// stopTimer stops the goroutine waiting for the timer and waits for it to
+// exit, so that it never closes the cancel channel afterwards. It must be
+// called with d.mu held.
+func (d *deadline) stopTimer() {
+	if d.stopc == nil {
+		return
+	}
+	close(d.stopc)
+	<-d.exited
+	d.stopc, d.exited = nil, nil
+}
+
+// done returns the channel that is closed when the deadline expires.
+func (d *deadline) done() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+// context returns a context.Context which is done when the deadline expires.
+func (d *deadline) context() deadlineContext {
+	return deadlineContext{done: d.done()}
+}
+
+// isClosedChan returns whether c is closed.
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// deadlineContext is a context.Context which is done when a deadline expires.
+// It is used to stop waiting for the limiter at the deadline.
+type deadlineContext struct {
+	done <-chan struct{}
+}
+
+// Deadline returns no deadline, since the deadline may be changed.
+func (c deadlineContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+// Done returns the channel that is closed when the deadline expires.
+func (c deadlineContext) Done() <-chan struct{} { return c.done }
+
+// Err returns errTimeout if the deadline has expired.
+func (c deadlineContext) Err() error {
+	if isClosedChan(c.done) {
+		return errTimeout
+	}
+	return nil
+}
+
+// Value returns nil.
+func (c deadlineContext) Value(interface{}) interface{} { return nil }
+
+// errTimeout is the error returned when the deadline expires. It is the same
+// error as the one returned by the connections of the net package, which is a
+// net.Error whose Timeout method returns true.
+var errTimeout = os.ErrDeadlineExceeded
@@ -16,3 +16,11 @@ var ErrZeroWrite = errors.New("speedio: zero bytes written")
 
 // ErrInvalidParameter is the error thrown when a parameter is invalid.
 var ErrInvalidParameter = errors.New("speedio: invalid parameter")
+
+// ErrNotSupported is the error thrown when the underlying object does not
+// support the operation.
+var ErrNotSupported = errors.New("speedio: not supported")
+
+// ErrWouldBlock is the error returned by TryRead and TryWrite when the transfer
+// is not allowed without waiting.
+var ErrWouldBlock = errors.New("speedio: operation would block")
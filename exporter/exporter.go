@@ -0,0 +1,308 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+/*
+Package exporter exports the statistics of the speedio meters and limiters in
+the Prometheus text exposition format, so that they can be scraped by
+Prometheus without depending on the Prometheus client library.
+
+The meters and limiters are registered to a Registry with their names and
+labels, and the Registry serves the metrics as an http.Handler:
+
+	reg := exporter.NewRegistry()
+	_ = reg.Register("upload", exporter.Labels{"peer": "backup"}, w)
+	http.Handle("/metrics", reg)
+*/
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+	"github.com/tunabay/go-speedio"
+)
+
+// Labels is a set of the label names and values attached to the metrics of a
+// registered instance.
+type Labels map[string]string
+
+// Meter is the interface of the meters that can be registered, such as
+// speedio.MeterReader, speedio.MeterWriter, speedio.MeterGroup,
+// speedio.Reader and speedio.Writer.
+type Meter interface {
+	BitRate() infounit.BitRate
+	Total() (infounit.ByteCount, time.Duration, infounit.BitRate)
+}
+
+// Limiter is the interface of the limiters that can be registered, such as
+// speedio.LimiterReader, speedio.LimiterWriter, speedio.LimiterGroup,
+// speedio.Limiter, speedio.Reader and speedio.Writer.
+type Limiter interface {
+	LimitingBitRate() infounit.BitRate
+	ThrottledTime() time.Duration
+}
+
+// ContentType is the content type of the Prometheus text exposition format
+// served by Registry.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// NameLabel is the name of the label holding the registered name.
+const NameLabel = "name"
+
+// metric is a metric exported for each instance.
+type metric struct {
+	name, typ, help string
+	value           func(e *entry) (float64, bool)
+}
+
+// metrics are the metrics exported by Registry, in the order of the output.
+var metrics = []metric{
+	{
+		name: "speedio_bytes_total",
+		typ:  "counter",
+		help: "Total number of bytes transferred.",
+		value: func(e *entry) (float64, bool) {
+			if e.met == nil {
+				return 0, false
+			}
+			b, _, _ := e.met.Total()
+			return float64(b), true
+		},
+	},
+	{
+		name: "speedio_throttle_wait_seconds_total",
+		typ:  "counter",
+		help: "Total time waited for the limiting bit rate in seconds.",
+		value: func(e *entry) (float64, bool) {
+			if e.lim == nil {
+				return 0, false
+			}
+			return e.lim.ThrottledTime().Seconds(), true
+		},
+	},
+	{
+		name: "speedio_bits_per_second",
+		typ:  "gauge",
+		help: "Bit rate in the most recent sampling period in bits per second.",
+		value: func(e *entry) (float64, bool) {
+			if e.met == nil {
+				return 0, false
+			}
+			return float64(e.met.BitRate()), true
+		},
+	},
+	{
+		name: "speedio_limit_bits_per_second",
+		typ:  "gauge",
+		help: "Current limiting bit rate in bits per second.",
+		value: func(e *entry) (float64, bool) {
+			if e.lim == nil {
+				return 0, false
+			}
+			return float64(e.lim.LimitingBitRate()), true
+		},
+	},
+}
+
+// Registry is a set of the named meters and limiters, which implements
+// http.Handler serving their metrics in the Prometheus text exposition format.
+//
+// The counters speedio_bytes_total and speedio_throttle_wait_seconds_total,
+// and the gauges speedio_bits_per_second and speedio_limit_bits_per_second are
+// exported for each instance, with the label NameLabel holding the registered
+// name and the labels specified at the registration. The metrics that the
+// instance does not implement are omitted.
+//
+// An instance is identified by its name and labels, so that the same name can
+// be registered with different labels, such as the direction or the peer.
+type Registry struct {
+	entries map[string]*entry // by the formatted label set
+	mu      sync.RWMutex
+}
+
+// entry is a registered instance.
+type entry struct {
+	labels string // formatted label set including the braces
+	met    Meter
+	lim    Limiter
+}
+
+// NewRegistry creates a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]*entry)}
+}
+
+// Register registers v with the name and the labels. v must implement Meter,
+// Limiter, or both. The pair of the name and the labels must be unique in the
+// Registry, and the label names must be valid Prometheus label names other
+// than NameLabel.
+func (r *Registry) Register(name string, labels Labels, v interface{}) error {
+	e := &entry{}
+	e.met, _ = v.(Meter)
+	e.lim, _ = v.(Limiter)
+	if e.met == nil && e.lim == nil {
+		return fmt.Errorf("%w: %T is neither a meter nor a limiter", speedio.ErrInvalidParameter, v)
+	}
+	for k := range labels {
+		switch {
+		case k == NameLabel:
+			return fmt.Errorf("%w: reserved label name %q", speedio.ErrInvalidParameter, k)
+		case !validLabelName(k):
+			return fmt.Errorf("%w: invalid label name %q", speedio.ErrInvalidParameter, k)
+		}
+	}
+	e.labels = formatLabels(name, labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[e.labels]; ok {
+		return fmt.Errorf("%w: %s already registered", speedio.ErrInvalidParameter, e.labels)
+	}
+	r.entries[e.labels] = e
+	return nil
+}
+
+// Unregister removes the instance registered with the name and the labels. It
+// returns false if no instance is registered with them.
+func (r *Registry) Unregister(name string, labels Labels) bool {
+	key := formatLabels(name, labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[key]; !ok {
+		return false
+	}
+	delete(r.entries, key)
+	return true
+}
+
+// formatLabels returns the label set of the name and the labels in the
+// exposition format, with the labels sorted by their names.
+func formatLabels(name string, labels Labels) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("{" + NameLabel + "=")
+	writeLabelValue(&sb, name)
+	for _, k := range keys {
+		sb.WriteString("," + k + "=")
+		writeLabelValue(&sb, labels[k])
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// WriteTo writes the metrics of all the registered instances to w in the
+// Prometheus text exposition format. The instances are sorted by their names
+// and labels.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	entries := make([]*entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].labels < entries[j].labels })
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		header := false
+		for _, e := range entries {
+			v, ok := m.value(e)
+			if !ok {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+				header = true
+			}
+			fmt.Fprintf(bw, "%s%s %s\n", m.name, e.labels, formatValue(v))
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP writes the metrics of all the registered instances as the
+// response in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	if req.Method == http.MethodHead {
+		return
+	}
+	_, _ = r.WriteTo(w)
+}
+
+// countWriter counts the bytes written to the underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes p to the underlying writer.
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// validLabelName returns whether s is a valid Prometheus label name. The names
+// beginning with "__" are reserved for the internal use of Prometheus.
+func validLabelName(s string) bool {
+	if s == "" || strings.HasPrefix(s, "__") {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && 0 < i:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// writeLabelValue writes the label value v quoted and escaped.
+func writeLabelValue(sb *strings.Builder, v string) {
+	sb.WriteByte('"')
+	for _, c := range v {
+		switch c {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\n':
+			sb.WriteString(`\n`)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	sb.WriteByte('"')
+}
+
+// formatValue formats the sample value v.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package exporter_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/exporter"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestRegistry_test1(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	mw, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, &speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 2,
+		Clock:      clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lim, err := speedio.NewLimiterWithConfig(8000, &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Second,
+		Clock:      clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := exporter.NewRegistry()
+	if err := reg.Register("up", exporter.Labels{"peer": `a"b\c`, "dir": "tx"}, mw); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register("dgram", nil, lim); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mw.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	if _, err := mw.Write(make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+
+	done := make(chan error)
+	go func() {
+		if err := lim.Wait(context.Background(), 1000); err != nil {
+			done <- err
+			return
+		}
+		done <- lim.Wait(context.Background(), 1000)
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(time.Millisecond * 1500)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != exporter.ContentType {
+		t.Errorf("unexpected content type: %q", ct)
+	}
+	want := strings.Join([]string{
+		"# HELP speedio_bytes_total Total number of bytes transferred.",
+		"# TYPE speedio_bytes_total counter",
+		`speedio_bytes_total{name="up",dir="tx",peer="a\"b\\c"} 1500`,
+		"# HELP speedio_throttle_wait_seconds_total Total time waited for the limiting bit rate in seconds.",
+		"# TYPE speedio_throttle_wait_seconds_total counter",
+		`speedio_throttle_wait_seconds_total{name="dgram"} 1.5`,
+		"# HELP speedio_bits_per_second Bit rate in the most recent sampling period in bits per second.",
+		"# TYPE speedio_bits_per_second gauge",
+		`speedio_bits_per_second{name="up",dir="tx",peer="a\"b\\c"} 2000`,
+		"# HELP speedio_limit_bits_per_second Current limiting bit rate in bits per second.",
+		"# TYPE speedio_limit_bits_per_second gauge",
+		`speedio_limit_bits_per_second{name="dgram"} 8000`,
+		"",
+	}, "\n")
+	if got := rec.Body.String(); got != want {
+		t.Errorf("unexpected output:\nwant:\n%s\ngot:\n%s", want, got)
+	}
+
+	if !reg.Unregister("dgram", nil) || reg.Unregister("dgram", nil) {
+		t.Error("unexpected unregister result")
+	}
+	var sb strings.Builder
+	if _, err := reg.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sb.String(), "dgram") {
+		t.Errorf("unregistered instance exported:\n%s", sb.String())
+	}
+}
+
+//
+func TestRegistry_invalid(t *testing.T) {
+	t.Parallel()
+
+	reg := exporter.NewRegistry()
+	mw := speedio.NewMeterWriter(ioutil.Discard)
+	tests := []struct {
+		name   string
+		labels exporter.Labels
+		v      interface{}
+	}{
+		{"a", nil, struct{}{}},
+		{"b", exporter.Labels{"name": "x"}, mw},
+		{"c", exporter.Labels{"__x": "x"}, mw},
+		{"d", exporter.Labels{"1x": "x"}, mw},
+		{"e", exporter.Labels{"x-y": "x"}, mw},
+	}
+	for _, tc := range tests {
+		if err := reg.Register(tc.name, tc.labels, tc.v); !errors.Is(err, speedio.ErrInvalidParameter) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+	if err := reg.Register("f", nil, mw); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register("f", nil, mw); !errors.Is(err, speedio.ErrInvalidParameter) {
+		t.Errorf("unexpected error for duplicate name: %v", err)
+	}
+	if err := reg.Register("g", exporter.Labels{"dir": "tx", "peer": "a"}, mw); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register("g", exporter.Labels{"dir": "rx", "peer": "a"}, mw); err != nil {
+		t.Errorf("unexpected error for different labels: %v", err)
+	}
+	if err := reg.Register("g", exporter.Labels{"peer": "a", "dir": "tx"}, mw); !errors.Is(err, speedio.ErrInvalidParameter) {
+		t.Errorf("unexpected error for duplicate labels: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+/*
+Package fakeclock implements a speedio.Clock that is advanced manually. It is
+intended to be used in tests of the limiters and meters, so that they finish
+instantly and give exact, repeatable results.
+*/
+package fakeclock
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tunabay/go-speedio"
+)
+
+// Clock is a speedio.Clock whose time is advanced only by Advance or Set.
+type Clock struct {
+	now    time.Time
+	timers map[*timer]struct{}
+	mu     sync.Mutex
+	cond   *sync.Cond
+}
+
+// timer is a speedio.Timer created by Clock.
+type timer struct {
+	clk  *Clock
+	when time.Time
+	ch   chan time.Time
+}
+
+// New creates a new Clock whose current time is now.
+func New(now time.Time) *Clock {
+	c := &Clock{
+		now:    now,
+		timers: make(map[*timer]struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer creates a new timer that fires when the clock is advanced by d or
+// more. If d is not positive, the timer fires immediately.
+func (c *Clock) NewTimer(d time.Duration) speedio.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &timer{
+		clk:  c,
+		when: c.now.Add(d),
+		ch:   make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.timers[t] = struct{}{}
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance advances the clock by d, and fires the timers that expire.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set sets the current time of the clock to tc, and fires the timers that
+// expire. Setting a time before the current time does not fire any timer.
+func (c *Clock) Set(tc time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(tc)
+}
+
+//
+func (c *Clock) set(tc time.Time) {
+	c.now = tc
+	for t := range c.timers {
+		if !t.when.After(tc) {
+			delete(c.timers, t)
+			t.ch <- tc
+		}
+	}
+	c.cond.Broadcast()
+}
+
+// Next returns the time when the earliest pending timer fires. If there is no
+// pending timer, it returns false.
+func (c *Clock) Next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var next time.Time
+	for t := range c.timers {
+		if next.IsZero() || t.when.Before(next) {
+			next = t.when
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Pending returns the number of the pending timers.
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil blocks until at least n timers are pending. It is used to wait
+// until the goroutines under test start waiting for the clock.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// C returns the channel on which the time is delivered when the timer fires.
+func (t *timer) C() <-chan time.Time {
+	return t.ch
+}
+
+// Stop prevents the timer from firing. It returns false if the timer has
+// already fired or been stopped.
+func (t *timer) Stop() bool {
+	t.clk.mu.Lock()
+	defer t.clk.mu.Unlock()
+	if _, ok := t.clk.timers[t]; !ok {
+		return false
+	}
+	delete(t.clk.timers, t)
+	t.clk.cond.Broadcast()
+	return true
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package fakeclock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestClock_test1(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	c := fakeclock.New(t0)
+	t1 := c.NewTimer(time.Second)
+	t2 := c.NewTimer(time.Second * 2)
+	if n := c.Pending(); n != 2 {
+		t.Errorf("unexpected pending timers: want: 2, got: %d", n)
+	}
+	if next, ok := c.Next(); !ok || !next.Equal(t0.Add(time.Second)) {
+		t.Errorf("unexpected next: %v, %v", next, ok)
+	}
+
+	c.Advance(time.Millisecond * 999)
+	select {
+	case <-t1.C():
+		t.Error("fired too early")
+	default:
+	}
+	c.Advance(time.Millisecond)
+	select {
+	case tc := <-t1.C():
+		if !tc.Equal(t0.Add(time.Second)) {
+			t.Errorf("unexpected time: %v", tc)
+		}
+	default:
+		t.Error("not fired")
+	}
+	if t1.Stop() {
+		t.Error("Stop of a fired timer returned true")
+	}
+	if !t2.Stop() {
+		t.Error("Stop of a pending timer returned false")
+	}
+	c.Advance(time.Hour)
+	select {
+	case <-t2.C():
+		t.Error("stopped timer fired")
+	default:
+	}
+	if got := c.Now(); !got.Equal(t0.Add(time.Hour + time.Second)) {
+		t.Errorf("unexpected now: %v", got)
+	}
+}
+
+//
+func TestClock_blockUntil(t *testing.T) {
+	t.Parallel()
+
+	c := fakeclock.New(time.Time{}.Add(time.Hour))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-c.NewTimer(time.Minute).C()
+	}()
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	<-done
+}
@@ -1,6 +1,6 @@
 module github.com/tunabay/go-speedio
 
-go 1.14
+go 1.15
 
 require (
 	github.com/tunabay/go-infounit v1.1.0
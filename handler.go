@@ -0,0 +1,170 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// Handler implements an http.Handler middleware that limits and measures the
+// response bodies written by the wrapped Handler. The limit is chosen per
+// request, for example by the client address, the authentication tier or the
+// path.
+//
+// The http.ResponseWriter passed to the wrapped Handler keeps implementing
+// http.Flusher and http.Hijacker if the underlying ResponseWriter implements
+// them, and it always implements io.ReaderFrom through the limiter. The
+// waiting for the limiter stops when the context of the request is done. Data
+// written to a hijacked connection is neither limited nor measured.
+type Handler struct {
+	// Handler is the wrapped handler.
+	Handler http.Handler
+
+	// BitRate returns the limiting bit rate of the response body for the
+	// request. A zero bit rate means no limit. If nil, the response bodies
+	// are only measured.
+	BitRate func(r *http.Request) infounit.BitRate
+
+	// LimiterConfig and MeterConfig are the configurations used for the
+	// limiters and the meters. If nil, the default configurations will be
+	// used.
+	LimiterConfig *LimiterConfig
+	MeterConfig   *MeterConfig
+
+	// Done, if not nil, is called with the closed meter of the response
+	// body after the wrapped Handler returns.
+	Done func(r *http.Request, m *MeterWriter)
+}
+
+// ServeHTTP calls the wrapped Handler with the limited ResponseWriter. If the
+// limiter or the meter cannot be created, it responds with the status 500.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rate infounit.BitRate
+	if h.BitRate != nil {
+		rate = h.BitRate(r)
+	}
+	mw, err := NewMeterWriterWithConfig(w, h.MeterConfig)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	rw := &responseWriter{rw: w, mw: mw, req: r}
+	if rate != 0 {
+		if rw.lw, err = NewLimiterWriterWithConfig(mw, rate, h.LimiterConfig); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	defer func() {
+		if rw.lw != nil {
+			_ = rw.lw.CloseSingle()
+		}
+		_ = mw.CloseSingle()
+		if h.Done != nil {
+			h.Done(r, mw)
+		}
+	}()
+	h.Handler.ServeHTTP(rw.wrap(), r)
+}
+
+// responseWriter is an http.ResponseWriter that limits and measures the
+// response body.
+type responseWriter struct {
+	rw  http.ResponseWriter
+	mw  *MeterWriter
+	lw  *LimiterWriter // nil if not limited
+	req *http.Request
+	fl  http.Flusher  // nil if not implemented by rw
+	hj  http.Hijacker // nil if not implemented by rw
+}
+
+// wrap returns the responseWriter combined with the optional interfaces that
+// the underlying ResponseWriter implements.
+func (w *responseWriter) wrap() http.ResponseWriter {
+	w.fl, _ = w.rw.(http.Flusher)
+	w.hj, _ = w.rw.(http.Hijacker)
+	switch {
+	case w.fl != nil && w.hj != nil:
+		return &flushHijackResponseWriter{w}
+	case w.fl != nil:
+		return &flushResponseWriter{w}
+	case w.hj != nil:
+		return &hijackResponseWriter{w}
+	}
+	return w
+}
+
+// Header returns the header map of the underlying ResponseWriter.
+func (w *responseWriter) Header() http.Header {
+	return w.rw.Header()
+}
+
+// WriteHeader sends the HTTP response header with the status code.
+func (w *responseWriter) WriteHeader(statusCode int) {
+	w.rw.WriteHeader(statusCode)
+}
+
+// Write writes the data to the response body. If the context of the request is
+// done while waiting for the limiter, it returns the number of bytes already
+// written and the error of the context.
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.lw == nil {
+		return w.mw.Write(p)
+	}
+	return w.lw.WriteContext(w.req.Context(), p)
+}
+
+// ReadFrom reads data from src until EOF and writes it to the response body.
+func (w *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(writerOnly{w}, src)
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.rw
+}
+
+// writerOnly hides the ReadFrom method to avoid the recursion of io.Copy.
+type writerOnly struct {
+	io.Writer
+}
+
+// flush sends the buffered data to the client.
+func (w *responseWriter) flush() {
+	w.fl.Flush()
+}
+
+// hijack lets the caller take over the connection.
+func (w *responseWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hj.Hijack()
+}
+
+//
+type flushResponseWriter struct{ *responseWriter }
+
+//
+func (w *flushResponseWriter) Flush() { w.flush() }
+
+//
+type hijackResponseWriter struct{ *responseWriter }
+
+//
+func (w *hijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
+
+//
+type flushHijackResponseWriter struct{ *responseWriter }
+
+//
+func (w *flushHijackResponseWriter) Flush() { w.flush() }
+
+//
+func (w *flushHijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
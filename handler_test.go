@@ -0,0 +1,116 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+	"github.com/tunabay/go-speedio"
+)
+
+//
+func TestHandler_test1(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan infounit.ByteCount, 1)
+	h := &speedio.Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := w.(http.Flusher); !ok {
+				t.Error("http.Flusher is hidden")
+			}
+			if _, ok := w.(http.Hijacker); !ok {
+				t.Error("http.Hijacker is hidden")
+			}
+			if _, err := io.Copy(w, bytes.NewReader(make([]byte, 20000))); err != nil {
+				t.Error(err)
+			}
+		}),
+		BitRate: func(r *http.Request) infounit.BitRate {
+			if r.URL.Path == "/fast" {
+				return 0
+			}
+			return 80000
+		},
+		Done: func(r *http.Request, m *speedio.MeterWriter) {
+			bc, _, _ := m.Total()
+			done <- bc
+		},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// 20000 bytes at 80 kbit/s: 10000 bytes burst and 10000 bytes in the
+	// next 1s.
+	for _, path := range []string{"/fast", "/slow"} {
+		tc := time.Now()
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.Copy(ioutil.Discard, res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		et := time.Since(tc)
+		if n != 20000 {
+			t.Errorf("%s: unexpected body len: want: 20000, got: %d", path, n)
+		}
+		switch {
+		case path == "/fast" && time.Millisecond*500 < et:
+			t.Errorf("%s: too slow: %s", path, et)
+		case path == "/slow" && et < time.Millisecond*900:
+			t.Errorf("%s: too fast: %s", path, et)
+		}
+		if bc := <-done; bc != 20000 {
+			t.Errorf("%s: unexpected total: want: 20000, got: %v", path, bc)
+		}
+	}
+}
+
+//
+func TestHandler_cancel(t *testing.T) {
+	t.Parallel()
+
+	errc := make(chan error, 1)
+	h := &speedio.Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := w.(http.Flusher); !ok {
+				t.Error("http.Flusher is hidden")
+			}
+			if _, ok := w.(http.Hijacker); ok {
+				t.Error("http.Hijacker is unexpectedly implemented")
+			}
+			_, err := w.Write(make([]byte, 20000))
+			errc <- err
+		}),
+		BitRate: func(*http.Request) infounit.BitRate { return 8000 },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	time.AfterFunc(time.Millisecond*100, cancel)
+	tc := time.Now()
+	h.ServeHTTP(rec, req)
+	if et := time.Since(tc); time.Second < et {
+		t.Errorf("not cancelled: %s", et)
+	}
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error: want: %v, got: %v", context.Canceled, err)
+	}
+	if n := rec.Body.Len(); n == 0 || 20000 <= n {
+		t.Errorf("unexpected body len: %d", n)
+	}
+}
@@ -5,7 +5,9 @@
 package speedio
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -14,33 +16,129 @@ import (
 
 // limiter limits the transfer.
 type limiter struct {
-	rate       float64 // bytes per sec ( = bps / 8 )
-	burst      float64 // bytes
-	minPartial int
-	rateCoef   float64 // time.Second / rate
-	lastTime   time.Time
-	lastToken  float64 // bytes
+	bitRate    infounit.BitRate // current effective bit rate
+	target     infounit.BitRate // bit rate to be reached by the ramp
+	resolution time.Duration
+	maxWait    time.Duration
+	clock      Clock
+	algo       Algorithm
+	bucket     Bucket
+	ops        *tokenBucket // operations, nil if not limited
+	parent     *limiter
+	share      *shareMember // non-nil for a weighted member of a group
+	sched      *Schedule
+	schedNext  time.Time
+	trace      *Trace
+	traceLoop  bool
+	traceStart time.Time
+	traceNext  time.Time // zero if the bit rate never changes
+	stalled    bool      // the trace has a zero bit rate, bitRate is kept
+	ramp       *RampConfig
+	ramping    bool
+	rampFrom   infounit.BitRate
+	rampStart  time.Time
+	paused     bool
+	resumed    chan struct{} // closed when resumed
+	waited     time.Duration // total time throttled, excluding pauses
 	mu         sync.RWMutex
 }
 
-// resolution is the period for totaling the transfer amount to determine whether the bit rate is exceeded or not.
+// newLimiterWithConfig creates a limiter with the specified configuration.
+// If conf is nil, the default configuration will be used.
+//
+// The resolution is the period for totaling the transfer amount to determine whether the bit rate is exceeded or not.
 // For example, if the bit rate is 1 kbit/s and the resolution is 3s,
 // if there is no transfer in the previous 2 seconds, transfer of 3 kbit is allowed
 // in the next 1 second. However, when the resolution is 1s,
 // the transfer allowed per second is always 1 kbit.
 //
-// maxWait is the maximum waiting time when the transfer exceeds the bit rate.
+// The maxWait is the maximum waiting time when the transfer exceeds the bit rate.
 // After this maxWait time elapses, only the portion that can be transferred at that time is transferred.
-func newLimiter(rate infounit.BitRate, resolution, maxWait time.Duration) (*limiter, error) {
-	l := &limiter{}
-	if err := l.set(time.Time{}, rate, resolution, maxWait); err != nil {
+func newLimiterWithConfig(rate infounit.BitRate, conf *LimiterConfig) (*limiter, error) {
+	if conf == nil {
+		conf = DefaultLimiterConfig
+	}
+	if err := conf.Ramp.check(); err != nil {
+		return nil, err
+	}
+	l := &limiter{clock: SystemClock, algo: TokenBucket, ramp: conf.Ramp}
+	if conf.Clock != nil {
+		l.clock = conf.Clock
+	}
+	if conf.Algorithm != nil {
+		l.algo = conf.Algorithm
+	}
+	l.bucket = l.algo()
+	if err := l.set(time.Time{}, rate, conf.Resolution, conf.MaxWait); err != nil {
+		return nil, err
+	}
+	if err := l.setOpRate(conf.OpRate); err != nil {
 		return nil, err
 	}
 	return l, nil
 }
 
+// setOpRate sets the limit of the number of operations per second. If rate is
+// 0, the number of operations is not limited.
+func (l *limiter) setOpRate(rate float64) error {
+	switch {
+	case rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate):
+		return fmt.Errorf("%w: invalid operation rate %v", ErrInvalidParameter, rate)
+	case rate == 0:
+		l.ops = nil
+		return nil
+	}
+	burst := rate * l.resolution.Seconds()
+	if burst < 1 {
+		burst = 1
+	}
+	l.ops = &tokenBucket{}
+	l.ops.SetRate(time.Time{}, rate, burst, 1)
+	return nil
+}
+
 //
 func (l *limiter) set(tc time.Time, rate infounit.BitRate, resolution, maxWait time.Duration) error {
+	if err := checkRate(rate, resolution, maxWait); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.resolution = resolution
+	l.maxWait = maxWait
+	l.sched, l.trace = nil, nil
+	l.setTarget(tc, rate)
+
+	return nil
+}
+
+// setTarget sets the target bit rate. If the ramp is configured and the target
+// is higher than the current bit rate, the bit rate is increased gradually from
+// the current bit rate, or from the initial fraction of the target if it is
+// higher. Otherwise, the target is applied immediately. It must be called with
+// l.mu held.
+func (l *limiter) setTarget(tc time.Time, rate infounit.BitRate) {
+	l.target = rate
+	l.stalled = false
+	if l.ramp == nil || rate <= l.bitRate {
+		l.ramping = false
+		l.adjust(tc, rate)
+		return
+	}
+	from := infounit.BitRate(float64(rate) * l.ramp.Initial)
+	if from < l.bitRate {
+		from = l.bitRate
+	}
+	l.ramping = true
+	l.rampFrom, l.rampStart = from, tc // zero tc starts the ramp at the first transfer
+	l.adjust(tc, from)
+}
+
+// checkRate checks whether the bit rate can be limited with the resolution and
+// max-wait.
+func checkRate(rate infounit.BitRate, resolution, maxWait time.Duration) error {
 	switch {
 	case rate < 0:
 		return fmt.Errorf("%w: negative bit rate %v", ErrInvalidParameter, rate)
@@ -66,61 +164,482 @@ func (l *limiter) set(tc time.Time, rate infounit.BitRate, resolution, maxWait t
 	case newMinPartial < 1:
 		return fmt.Errorf("%w: rate and/or max-wait is too small: rate=%v, wait=%s", ErrInvalidParameter, rate, maxWait)
 	}
+	return nil
+}
 
+// adjust changes the effective bit rate without validation, keeping the
+// resolution and max-wait. The burst and the minimum partial transfer size are
+// rounded up to 1 byte if the bit rate is too small. It must be called with
+// l.mu held.
+func (l *limiter) adjust(tc time.Time, rate infounit.BitRate) {
+	l.bitRate = rate
+	byteRate := float64(rate) / 8
+	burst := byteRate * l.resolution.Seconds()
+	if burst < 1 {
+		burst = 1
+	}
+	minPartial := int(byteRate * l.maxWait.Seconds())
+	if minPartial < 1 {
+		minPartial = 1
+	}
+	l.bucket.SetRate(tc, byteRate, burst, minPartial)
+}
+
+// config returns the configuration of the limiter, except for the ramp.
+func (l *limiter) config() *LimiterConfig {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return &LimiterConfig{
+		Resolution: l.resolution,
+		MaxWait:    l.maxWait,
+		Clock:      l.clock,
+		Algorithm:  l.algo,
+	}
+}
+
+// update applies the changes of the bit rate driven by time, such as the
+// schedule, the trace and the ramp, at tc. A ramp not started yet keeps its
+// initial bit rate. It must be called with l.mu held.
+func (l *limiter) update(tc time.Time) {
+	if l.trace != nil && !l.traceNext.IsZero() && !tc.Before(l.traceNext) {
+		rate, next := l.trace.at(tc.Sub(l.traceStart), l.traceLoop)
+		l.traceNext = time.Time{}
+		if 0 <= next {
+			l.traceNext = l.traceStart.Add(next)
+		}
+		l.target, l.ramping, l.stalled = rate, false, rate == 0
+		if !l.stalled && rate != l.bitRate {
+			l.adjust(tc, rate)
+		}
+	}
+	if l.sched != nil && !tc.Before(l.schedNext) {
+		if rate := l.sched.bitRateAt(tc); rate != l.target {
+			l.setTarget(tc, rate)
+		}
+		l.schedNext = l.sched.nextChange(tc)
+	}
+	if l.ramping && !l.rampStart.IsZero() {
+		rate, done := l.ramp.at(l.rampFrom, l.target, tc.Sub(l.rampStart))
+		if rate != l.bitRate {
+			l.adjust(tc, rate)
+		}
+		l.ramping = !done
+	}
+}
+
+// startRamp starts the ramp not started yet at tc, which is the time of the
+// first transfer. It must be called with l.mu held, before update(tc).
+func (l *limiter) startRamp(tc time.Time) {
+	if l.ramping && l.rampStart.IsZero() {
+		l.rampStart = tc
+	}
+}
+
+// setSchedule sets the schedule of the bit rate. If s is nil, the schedule is
+// removed and the current bit rate is kept.
+func (l *limiter) setSchedule(tc time.Time, s *Schedule) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if s == nil {
+		l.sched = nil
+		return nil
+	}
+	if err := s.check(l.resolution, l.maxWait); err != nil {
+		return err
+	}
+	l.trace = nil
+	l.sched = s.clone()
+	l.schedNext = time.Time{}
+	l.update(tc)
+	return nil
+}
 
-	if !l.lastTime.IsZero() && !tc.IsZero() {
-		l.lastTime = tc
-		l.lastToken += tc.Sub(l.lastTime).Seconds() * l.rate
-		if l.burst < l.lastToken {
-			l.lastToken = l.burst
+// setTrace sets the trace of the bit rate, which starts at tc. If t is nil,
+// the trace is removed and the current bit rate is kept, unless it is zero. A
+// trace removes the schedule, if any.
+func (l *limiter) setTrace(tc time.Time, t *Trace, loop bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t == nil {
+		l.trace = nil
+		if l.stalled {
+			l.setTarget(tc, l.bitRate) // the last non-zero bit rate
 		}
+		return nil
+	}
+	if err := t.check(); err != nil {
+		return err
+	}
+	l.sched = nil
+	l.trace, l.traceLoop = t.clone(), loop
+	l.traceStart, l.traceNext = tc, tc
+	l.update(tc)
+	return nil
+}
+
+// setBitRate changes only the bit rate, keeping the resolution and max-wait.
+// It removes the schedule, if any.
+func (l *limiter) setBitRate(tc time.Time, rate infounit.BitRate) error {
+	l.mu.RLock()
+	resolution, maxWait := l.resolution, l.maxWait
+	l.mu.RUnlock()
+	return l.set(tc, rate, resolution, maxWait)
+}
+
+// partialSize returns the minimum partial transfer size at the target bit
+// rate, which is the size of the data transferred at once in a transfer
+// exceeding the bit rate.
+func (l *limiter) partialSize() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	n := int(float64(l.target) / 8 * l.maxWait.Seconds())
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// limitingBitRate returns the limiting bit rate at tc. It does not start the
+// ramp, so that reading the bit rate before the first transfer reports the
+// initial bit rate of the ramp.
+func (l *limiter) limitingBitRate(tc time.Time) infounit.BitRate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.update(tc)
+	if l.stalled {
+		return 0
 	}
-	l.rate = newRate
-	l.burst = newBurst
-	l.minPartial = newMinPartial
-	l.rateCoef = float64(time.Second) / l.rate
+	return l.bitRate
+}
 
+// pause pauses the transfers limited by the limiter, including the ones by its
+// descendants, until resume is called.
+func (l *limiter) pause() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.paused {
+		return
+	}
+	l.paused, l.resumed = true, make(chan struct{})
+}
+
+// resume resumes the transfers paused by pause.
+func (l *limiter) resume() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.paused {
+		return
+	}
+	l.paused = false
+	close(l.resumed)
+}
+
+// pausedChan returns a channel that is closed when the paused limiter is
+// resumed, checking the limiter and all its ancestors. It returns nil if none
+// of them is paused.
+func (l *limiter) pausedChan() <-chan struct{} {
+	for n := l; n != nil; n = n.parent {
+		n.mu.RLock()
+		paused, resumed := n.paused, n.resumed
+		n.mu.RUnlock()
+		if paused {
+			return resumed
+		}
+	}
 	return nil
 }
 
-// refund returns not used token.
+// addWaited records the time d waited for the tokens into the limiter and all
+// its ancestors.
+func (l *limiter) addWaited(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	for n := l; n != nil; n = n.parent {
+		n.mu.Lock()
+		n.waited += d
+		n.mu.Unlock()
+	}
+}
+
+// waitedTime returns the total time waited for the tokens, by the transfers
+// limited by the limiter and its descendants. The time waiting while paused is
+// not included.
+func (l *limiter) waitedTime() time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.waited
+}
+
+// leave removes the limiter from the group sharing the bit rate, if any.
+func (l *limiter) leave() {
+	if l.share != nil {
+		l.share.leave()
+	}
+}
+
+// refund returns not used token to the limiter and all its ancestors.
 func (l *limiter) refund(bc int) {
+	for n := l; n != nil; n = n.parent {
+		n.put(bc)
+	}
+}
+
+// cancel returns the tokens of bc bytes and ops operations that are not used
+// because the transfer is cancelled, to the limiter and all its ancestors.
+func (l *limiter) cancel(bc, ops int) {
+	for n := l; n != nil; n = n.parent {
+		n.put(bc)
+		n.mu.Lock()
+		if n.ops != nil {
+			n.ops.Refund(ops)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// put returns not used token only to the limiter itself.
+func (l *limiter) put(bc int) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.lastToken += float64(bc)
+	l.bucket.Refund(bc)
 }
 
 // request requests a transfer of the specified number of bytes.
 // It returns the duration to wait and the number of bytes allowed.
+// The transfer must be allowed by the limiter and all its ancestors, so the
+// longest waiting time and the smallest number of bytes are returned. The
+// tokens taken in excess of the smallest number are returned to each level.
 func (l *limiter) request(tc time.Time, bc int) (time.Duration, int) {
+	var wd time.Duration
+	for n := l; n != nil; n = n.parent {
+		d, abc := n.take(tc, bc)
+		if abc < bc {
+			for c := l; c != n; c = c.parent {
+				c.put(bc - abc)
+			}
+			bc = abc
+		}
+		if wd < d {
+			wd = d
+		}
+	}
+	return wd, bc
+}
+
+// reserve requests a transfer of exactly bc bytes as one operation. Unlike
+// request, the transfer is not divided, and it returns the duration to wait
+// until all the bytes are allowed. bc must not exceed the burst size of the
+// limiter and all its ancestors, so that the delay is bounded. The tokens are
+// taken at tc even while the trace has a zero bit rate, so that they can always
+// be returned by cancel, and the duration is at least until the trace of the
+// limiter and all its ancestors has a non-zero bit rate, which is
+// math.MaxInt64 if it never has.
+func (l *limiter) reserve(tc time.Time, bc int) (time.Duration, error) {
+	for n := l; n != nil; n = n.parent {
+		if burst := n.burstSize(tc); burst < bc {
+			return 0, fmt.Errorf("%w: size %d exceeds the burst %d", ErrInvalidParameter, bc, burst)
+		}
+	}
+	wd := l.stallDelay(tc)
+	for n := l; n != nil; n = n.parent {
+		if d := n.charge(tc, bc); wd < d {
+			wd = d
+		}
+	}
+	return wd, nil
+}
+
+// burstSize returns the burst size in bytes at tc, which is the maximum
+// amount of data allowed in the resolution.
+func (l *limiter) burstSize(tc time.Time) int {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	l.update(tc)
+	burst := int(float64(l.bitRate) / 8 * l.resolution.Seconds())
+	if burst < 1 {
+		burst = 1
+	}
+	return burst
+}
 
-	allowed := l.lastToken + l.rate*tc.Sub(l.lastTime).Seconds()
-	if l.burst < allowed {
-		allowed = l.burst
+// stallDelay returns the duration from tc until the trace of the limiter and
+// all its ancestors has a non-zero bit rate, or math.MaxInt64 if never.
+func (l *limiter) stallDelay(tc time.Time) time.Duration {
+	var wd time.Duration
+	for n := l; n != nil; n = n.parent {
+		n.mu.Lock()
+		n.update(tc)
+		d := n.resumeDelay(tc)
+		n.mu.Unlock()
+		if wd < d {
+			wd = d
+		}
 	}
-	allowedBytes := int(allowed)
+	return wd
+}
 
-	switch {
-	case bc <= allowedBytes:
-		l.lastTime = tc
-		l.lastToken = allowed - float64(bc)
-		return 0, bc
-	case l.minPartial <= allowedBytes:
-		l.lastTime = tc
-		l.lastToken = allowed - float64(allowedBytes)
-		return 0, allowedBytes
-	}
-
-	wsz := l.minPartial
-	if bc < wsz {
-		wsz = bc
-	}
-	d := time.Duration(l.rateCoef * (float64(wsz) - allowed))
-	l.lastTime = tc.Add(d)
-	l.lastToken = 0
-	return d, wsz
+// resumeDelay returns the duration from tc until the trace has a non-zero bit
+// rate, looking ahead in the trace without changing the state, or
+// math.MaxInt64 if never. It must be called with l.mu held, after update(tc).
+func (l *limiter) resumeDelay(tc time.Time) time.Duration {
+	if !l.stalled {
+		return 0
+	}
+	// Each step is visited at most once in a cycle of the trace.
+	at := l.traceNext
+	for i := 0; !at.IsZero() && i <= len(l.trace.Steps); i++ {
+		rate, next := l.trace.at(at.Sub(l.traceStart), l.traceLoop)
+		if rate != 0 {
+			return at.Sub(tc)
+		}
+		if next < 0 {
+			break
+		}
+		at = l.traceStart.Add(next)
+	}
+	return time.Duration(math.MaxInt64)
+}
+
+// charge takes the tokens of exactly bc bytes and an operation at tc only
+// from the limiter itself, regardless of the bit rate of the trace. The bytes
+// are taken in parts of up to the minimum partial transfer size, which are at
+// most resolution / max-wait parts since bc does not exceed the burst. It
+// returns the duration until all the bytes are allowed.
+func (l *limiter) charge(tc time.Time, bc int) time.Duration {
+	var share infounit.BitRate
+	if l.share != nil {
+		share = l.share.share(tc)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.startRamp(tc)
+	l.update(tc)
+	if l.share != nil && share != l.bitRate {
+		l.adjust(tc, share)
+	}
+	if bc <= 0 {
+		return 0
+	}
+	var wd time.Duration
+	if l.ops != nil {
+		wd, _ = l.ops.Request(tc, 1)
+	}
+	for 0 < bc {
+		d, abc := l.bucket.Request(tc, bc)
+		if wd < d {
+			wd = d
+		}
+		bc -= abc
+	}
+	return wd
+}
+
+// acquire requests a transfer of up to bc bytes and waits until it is allowed.
+// It returns the number of bytes allowed. While the limiter or any of its
+// ancestors is paused, it waits without taking tokens. If it is paused while
+// waiting for the tokens, they are returned and requested again after resumed.
+// If ctx is done or closed is closed while waiting, the tokens are returned to
+// the limiter and the error is returned.
+func (l *limiter) acquire(ctx context.Context, closed <-chan struct{}, bc int) (int, error) {
+	for {
+		for resumed := l.pausedChan(); resumed != nil; resumed = l.pausedChan() {
+			select {
+			case <-resumed:
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-closed:
+				return 0, ErrClosed
+			}
+		}
+		tc := l.clock.Now()
+		wd, abc := l.request(tc, bc)
+		if wd <= 0 {
+			return abc, nil
+		}
+		timer := l.clock.NewTimer(wd)
+		select {
+		case <-timer.C():
+			l.addWaited(l.clock.Now().Sub(tc))
+		case <-ctx.Done():
+			if !timer.Stop() {
+				<-timer.C()
+			}
+			l.cancel(abc, 1)
+			l.addWaited(l.clock.Now().Sub(tc))
+			return 0, ctx.Err()
+		case <-closed:
+			if !timer.Stop() {
+				<-timer.C()
+			}
+			l.cancel(abc, 1)
+			l.addWaited(l.clock.Now().Sub(tc))
+			return 0, ErrClosed
+		}
+		if l.pausedChan() != nil {
+			l.cancel(abc, 1) // paused while waiting, retry after resumed
+			continue
+		}
+		if 0 < abc || bc == 0 {
+			return abc, nil
+		}
+		// nothing was allowed because of a zero bit rate of the trace
+	}
+}
+
+// tryAcquire is the same as acquire except that it never waits. If the
+// transfer is not allowed immediately, the tokens are returned to the limiter
+// and it returns the duration until they are available with ErrWouldBlock.
+// While the limiter or any of its ancestors is paused, the duration is the
+// resolution, since the time to be resumed is unknown.
+func (l *limiter) tryAcquire(closed <-chan struct{}, bc int) (int, time.Duration, error) {
+	select {
+	case <-closed:
+		return 0, 0, ErrClosed
+	default:
+	}
+	if l.pausedChan() != nil {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return 0, l.resolution, ErrWouldBlock
+	}
+	wd, abc := l.request(l.clock.Now(), bc)
+	if 0 < wd {
+		l.cancel(abc, 1)
+		return 0, wd, ErrWouldBlock
+	}
+	return abc, 0, nil
+}
+
+// take is the same as request except that it only takes into account the
+// limiter itself, not its ancestors.
+func (l *limiter) take(tc time.Time, bc int) (time.Duration, int) {
+	var share infounit.BitRate
+	if l.share != nil {
+		share = l.share.share(tc)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.startRamp(tc)
+	l.update(tc)
+	if l.stalled {
+		if l.traceNext.IsZero() {
+			return time.Duration(math.MaxInt64), 0
+		}
+		return l.traceNext.Sub(tc), 0
+	}
+	if l.share != nil && share != l.bitRate {
+		l.adjust(tc, share)
+	}
+	wd, abc := l.bucket.Request(tc, bc)
+	if l.ops != nil {
+		if od, _ := l.ops.Request(tc, 1); wd < od {
+			wd = od
+		}
+	}
+	return wd, abc
 }
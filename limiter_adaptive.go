@@ -0,0 +1,126 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// AdaptiveConfig indicates the configuration of the adaptive rate control of a
+// LimiterWriter, which follows the AIMD (additive increase, multiplicative
+// decrease) scheme.
+//
+// Each write to the underlying writer is observed. A write that fails, or that
+// takes longer than Latency, is regarded as trouble, and the limiting bit rate
+// is multiplied by Decrease, but not below Min. While writes stay healthy, the
+// bit rate is raised by Increase every Interval, but not above Max. Trouble
+// within Interval after a decrease is ignored, so that a burst of trouble
+// caused by the same congestion cuts the bit rate only once.
+//
+// If Latency is 0, only the failures are regarded as trouble. If Interval is
+// 0, the resolution of the limiter is used.
+type AdaptiveConfig struct {
+	Min, Max infounit.BitRate
+	Increase infounit.BitRate
+	Decrease float64 // 0 < Decrease < 1
+	Latency  time.Duration
+	Interval time.Duration
+}
+
+// check checks whether the adaptive configuration is valid for the resolution
+// and max-wait of the limiter.
+func (c *AdaptiveConfig) check(resolution, maxWait time.Duration) error {
+	switch {
+	case c.Max < c.Min:
+		return fmt.Errorf("%w: adaptive max %v < min %v", ErrInvalidParameter, c.Max, c.Min)
+	case c.Increase <= 0:
+		return fmt.Errorf("%w: adaptive increase %v <= 0", ErrInvalidParameter, c.Increase)
+	case !(0 < c.Decrease && c.Decrease < 1):
+		return fmt.Errorf("%w: adaptive decrease %v out of range", ErrInvalidParameter, c.Decrease)
+	case c.Latency < 0:
+		return fmt.Errorf("%w: negative adaptive latency %s", ErrInvalidParameter, c.Latency)
+	case c.Interval < 0:
+		return fmt.Errorf("%w: negative adaptive interval %s", ErrInvalidParameter, c.Interval)
+	}
+	return checkRate(c.Min, resolution, maxWait)
+}
+
+// adaptive is the state of the adaptive rate control.
+type adaptive struct {
+	conf     *AdaptiveConfig // nil if disabled
+	interval time.Duration
+	last     time.Time // time of the last change
+	cut      time.Time // time of the last decrease
+	mu       sync.Mutex
+}
+
+// set sets the configuration, and returns the current bit rate clamped into
+// the range of the configuration.
+func (a *adaptive) set(tc time.Time, conf *AdaptiveConfig, resolution time.Duration, cur infounit.BitRate) infounit.BitRate {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.conf, a.last, a.cut = conf, tc, time.Time{}
+	if conf == nil {
+		return cur
+	}
+	a.interval = conf.Interval
+	if a.interval == 0 {
+		a.interval = resolution
+	}
+	return a.clamp(cur)
+}
+
+// clamp clamps the bit rate into the range of the configuration.
+func (a *adaptive) clamp(rate infounit.BitRate) infounit.BitRate {
+	switch {
+	case rate < a.conf.Min:
+		return a.conf.Min
+	case a.conf.Max < rate:
+		return a.conf.Max
+	}
+	return rate
+}
+
+// enabled returns whether the adaptive rate control is enabled.
+func (a *adaptive) enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.conf != nil
+}
+
+// observe observes a write that took d and ended at tc with err, and returns
+// the new bit rate and whether it should be changed from cur.
+func (a *adaptive) observe(tc time.Time, d time.Duration, err error, cur infounit.BitRate) (infounit.BitRate, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conf == nil {
+		return cur, false
+	}
+	var rate infounit.BitRate
+	trouble := err != nil || (0 < a.conf.Latency && a.conf.Latency < d)
+	switch {
+	case trouble && tc.Sub(a.cut) < a.interval:
+		return cur, false
+	case trouble:
+		rate = infounit.BitRate(float64(cur) * a.conf.Decrease)
+	case tc.Sub(a.last) < a.interval:
+		return cur, false
+	default:
+		rate = cur + a.conf.Increase
+	}
+	rate = a.clamp(rate)
+	if rate == cur {
+		return cur, false
+	}
+	a.last = tc
+	if trouble {
+		a.cut = tc
+	}
+	return rate, true
+}
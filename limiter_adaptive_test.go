@@ -0,0 +1,97 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+// troubleWriter is a writer that fails or takes time as configured.
+type troubleWriter struct {
+	clk   *fakeclock.Clock
+	fail  bool
+	delay time.Duration
+}
+
+// Write advances the clock by the delay and fails if configured.
+func (w *troubleWriter) Write(p []byte) (int, error) {
+	w.clk.Advance(w.delay)
+	if w.fail {
+		return 0, errors.New("downstream failure")
+	}
+	return len(p), nil
+}
+
+func TestLimiterWriter_adaptive(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	}
+	dst := &troubleWriter{clk: clk}
+	w, err := speedio.NewLimiterWriterWithConfig(dst, 40000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetAdaptive(&speedio.AdaptiveConfig{Min: 8000, Max: 20000, Increase: 8000, Decrease: 1}); err == nil {
+		t.Error("expected error for decrease out of range")
+	}
+	aconf := &speedio.AdaptiveConfig{
+		Min:      8000,
+		Max:      60000,
+		Increase: 8000,
+		Decrease: 0.5,
+		Latency:  time.Millisecond * 100,
+		Interval: time.Second,
+	}
+	if err := w.SetAdaptive(aconf); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		advance time.Duration
+		fail    bool
+		delay   time.Duration
+		rate    infounit.BitRate
+	}{
+		{0, false, 0, 40000},                     // within the interval
+		{time.Second, false, 0, 48000},           // additive increase
+		{0, true, 0, 24000},                      // failure
+		{0, true, 0, 24000},                      // same congestion
+		{time.Second, false, time.Second, 12000}, // too slow
+		{time.Second, false, 0, 20000},           // recovered
+		{time.Second * 10, false, 0, 28000},      // one step per write
+		{0, true, 0, 14000},                      // failure after increase
+		{time.Second, true, 0, 8000},             // floor
+		{time.Second, true, 0, 8000},             // floor
+		{time.Second, false, 0, 16000},           // recovered
+	}
+	for i, step := range steps {
+		clk.Advance(step.advance)
+		dst.fail, dst.delay = step.fail, step.delay
+		_, _, _ = w.TryWrite(make([]byte, 1))
+		if rate := w.LimitingBitRate(); rate != step.rate {
+			t.Errorf("step %d: unexpected rate: want: %v, got: %v", i, step.rate, rate)
+		}
+	}
+
+	// The current bit rate is clamped into the new range.
+	aconf.Min, aconf.Max = 20000, 30000
+	if err := w.SetAdaptive(aconf); err != nil {
+		t.Fatal(err)
+	}
+	if rate := w.LimitingBitRate(); rate != 20000 {
+		t.Errorf("unexpected rate: want: 20000, got: %v", rate)
+	}
+}
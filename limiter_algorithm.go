@@ -0,0 +1,260 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"time"
+)
+
+// Bucket is the interface implemented by an instance of a limiting algorithm.
+// The methods are always called with the lock of the limiter held, so that
+// implementations need not be safe for concurrent use.
+//
+// SetRate sets the rate in bytes per second, the burst size in bytes allowed
+// in the resolution, and the minimum size of the partial transfer in bytes
+// allowed in the max-wait duration. It is called before the first Request
+// with the zero time, and then whenever the bit rate is changed at tc.
+//
+// Request requests a transfer of bc bytes at tc. It returns the duration to
+// wait and the number of bytes allowed after the wait. It should allow a
+// transfer of at least min(bc, minPartial) bytes, either immediately or after
+// the wait. The allowed bytes are regarded as transferred.
+//
+// Refund returns bc bytes that were allowed by Request but not transferred. A
+// Request immediately followed by a Refund of all the allowed bytes should
+// leave the bucket in the equivalent state as before the Request.
+type Bucket interface {
+	SetRate(tc time.Time, rate, burst float64, minPartial int)
+	Request(tc time.Time, bc int) (time.Duration, int)
+	Refund(bc int)
+}
+
+// Algorithm is a function that creates a new Bucket of a limiting algorithm.
+// It is specified by LimiterConfig.
+type Algorithm func() Bucket
+
+// TokenBucket creates a Bucket of the token bucket algorithm, which is the
+// default. Tokens are added at the rate up to the burst size, so that the
+// amount of data allowed in the resolution can be transferred at once after
+// an idle period.
+func TokenBucket() Bucket {
+	return &tokenBucket{}
+}
+
+// LeakyBucket creates a Bucket of the leaky bucket algorithm. It allows no
+// burst, and the output is kept at the constant rate in chunks of up to the
+// size transferred in the max-wait duration. An idle period does not allow
+// the following transfer to exceed the rate.
+func LeakyBucket() Bucket {
+	return &leakyBucket{}
+}
+
+// SlidingLog creates a Bucket of the sliding log algorithm. It records the
+// time and the size of each transfer, and strictly allows the amount of data
+// of the burst size in any period of the resolution.
+func SlidingLog() Bucket {
+	return &slidingLog{}
+}
+
+// tokenBucket implements the token bucket algorithm.
+type tokenBucket struct {
+	rate       float64 // bytes per sec ( = bps / 8 )
+	burst      float64 // bytes
+	minPartial int
+	rateCoef   float64 // time.Second / rate
+	lastTime   time.Time
+	lastToken  float64 // bytes
+}
+
+// SetRate converts the tokens accumulated at the current rate until tc, and
+// then changes the rate.
+func (b *tokenBucket) SetRate(tc time.Time, rate, burst float64, minPartial int) {
+	if !b.lastTime.IsZero() && !tc.IsZero() {
+		b.lastToken += tc.Sub(b.lastTime).Seconds() * b.rate
+		b.lastTime = tc
+		if b.burst < b.lastToken {
+			b.lastToken = b.burst
+		}
+	}
+	b.rate = rate
+	b.burst = burst
+	b.minPartial = minPartial
+	b.rateCoef = float64(time.Second) / b.rate
+}
+
+// Refund returns not used token.
+func (b *tokenBucket) Refund(bc int) {
+	b.lastToken += float64(bc)
+}
+
+// Request requests a transfer of the specified number of bytes.
+// It returns the duration to wait and the number of bytes allowed.
+func (b *tokenBucket) Request(tc time.Time, bc int) (time.Duration, int) {
+	allowed := b.lastToken + b.rate*tc.Sub(b.lastTime).Seconds()
+	if b.burst < allowed {
+		allowed = b.burst
+	}
+	allowedBytes := int(allowed)
+
+	switch {
+	case bc <= allowedBytes:
+		b.lastTime = tc
+		b.lastToken = allowed - float64(bc)
+		return 0, bc
+	case b.minPartial <= allowedBytes:
+		b.lastTime = tc
+		b.lastToken = allowed - float64(allowedBytes)
+		return 0, allowedBytes
+	}
+
+	wsz := b.minPartial
+	if bc < wsz {
+		wsz = bc
+	}
+	d := time.Duration(b.rateCoef * (float64(wsz) - allowed))
+	b.lastTime = tc.Add(d)
+	b.lastToken = 0
+	return d, wsz
+}
+
+// leakyBucket implements the leaky bucket algorithm as a meter, which
+// schedules each transfer after the previous one has drained at the rate.
+type leakyBucket struct {
+	rate       float64 // bytes per sec
+	minPartial int
+	next       time.Time // time when the bucket becomes empty
+}
+
+// SetRate drains the remaining data in the bucket at the new rate.
+func (b *leakyBucket) SetRate(tc time.Time, rate, _ float64, minPartial int) {
+	if !tc.IsZero() && b.next.After(tc) {
+		remain := b.next.Sub(tc).Seconds() * b.rate
+		b.next = tc.Add(time.Duration(remain / rate * float64(time.Second)))
+	}
+	b.rate = rate
+	b.minPartial = minPartial
+}
+
+// Refund removes the data not transferred from the bucket.
+func (b *leakyBucket) Refund(bc int) {
+	b.next = b.next.Add(-b.duration(bc))
+}
+
+// Request schedules a transfer of up to minPartial bytes when the bucket
+// becomes empty.
+func (b *leakyBucket) Request(tc time.Time, bc int) (time.Duration, int) {
+	start := b.next
+	if start.Before(tc) {
+		start = tc
+	}
+	if b.minPartial < bc {
+		bc = b.minPartial
+	}
+	b.next = start.Add(b.duration(bc))
+	return start.Sub(tc), bc
+}
+
+// duration returns the time to drain bc bytes.
+func (b *leakyBucket) duration(bc int) time.Duration {
+	return time.Duration(float64(bc) / b.rate * float64(time.Second))
+}
+
+// slidingLog implements the sliding log algorithm.
+type slidingLog struct {
+	window     time.Duration
+	limit      float64 // bytes allowed in the window
+	minPartial int
+	used       float64 // sum of the entries
+	entries    []slidingLogEntry
+}
+
+// slidingLogEntry is a record of a transfer, sorted by time.
+type slidingLogEntry struct {
+	time time.Time
+	size float64
+}
+
+// SetRate changes the window and the amount of data allowed in it. The
+// window is the resolution.
+func (b *slidingLog) SetRate(_ time.Time, rate, burst float64, minPartial int) {
+	b.window = time.Duration(burst / rate * float64(time.Second))
+	b.limit = burst
+	b.minPartial = minPartial
+}
+
+// Refund removes the data not transferred from the latest entries.
+func (b *slidingLog) Refund(bc int) {
+	r := float64(bc)
+	for 0 < r && 0 < len(b.entries) {
+		e := &b.entries[len(b.entries)-1]
+		if r < e.size {
+			e.size -= r
+			b.used -= r
+			return
+		}
+		r -= e.size
+		b.used -= e.size
+		b.entries = b.entries[:len(b.entries)-1]
+	}
+}
+
+// Request allows the transfer if the sum of the transfers in the window ending
+// at tc does not exceed the limit. Otherwise, it waits until the old entries
+// leave the window.
+func (b *slidingLog) Request(tc time.Time, bc int) (time.Duration, int) {
+	expired := 0
+	for _, e := range b.entries {
+		if e.time.Add(b.window).After(tc) {
+			break
+		}
+		b.used -= e.size
+		expired++
+	}
+	if 0 < expired {
+		b.entries = append(b.entries[:0], b.entries[expired:]...)
+	}
+
+	allowed := b.limit - b.used
+	allowedBytes := int(allowed)
+	switch {
+	case bc <= allowedBytes:
+		b.add(tc, bc)
+		return 0, bc
+	case b.minPartial <= allowedBytes:
+		b.add(tc, allowedBytes)
+		return 0, allowedBytes
+	}
+
+	wsz := b.minPartial
+	if bc < wsz {
+		wsz = bc
+	}
+	if limit := int(b.limit); limit < wsz {
+		wsz = limit
+	}
+	at := tc
+	need := float64(wsz) - allowed
+	for _, e := range b.entries {
+		if need <= 0 {
+			break
+		}
+		need -= e.size
+		at = e.time.Add(b.window)
+	}
+	b.add(at, wsz)
+	return at.Sub(tc), wsz
+}
+
+// add inserts a new entry keeping the entries sorted by time.
+func (b *slidingLog) add(tc time.Time, bc int) {
+	i := len(b.entries)
+	for 0 < i && tc.Before(b.entries[i-1].time) {
+		i--
+	}
+	b.entries = append(b.entries, slidingLogEntry{})
+	copy(b.entries[i+1:], b.entries[i:])
+	b.entries[i] = slidingLogEntry{time: tc, size: float64(bc)}
+	b.used += float64(bc)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+// writeFake writes n bytes to w while advancing clk to each timer, and returns
+// the elapsed time on clk.
+func writeFake(t *testing.T, clk *fakeclock.Clock, w *speedio.LimiterWriter, n int) time.Duration {
+	t.Helper()
+	t0 := clk.Now()
+	errc := make(chan error)
+	go func() {
+		_, err := w.Write(make([]byte, n))
+		errc <- err
+	}()
+	for {
+		// wait for either the end of the write or a new timer
+		select {
+		case err := <-errc:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return clk.Now().Sub(t0)
+		default:
+		}
+		if next, ok := clk.Next(); ok {
+			clk.Set(next)
+			continue
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//
+func TestLimiterAlgorithm_test1(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name  string
+		algo  speedio.Algorithm
+		first time.Duration // 250 bytes from the start
+		idle  time.Duration // 250 bytes after 10s idle
+	}{
+		// 125 bytes burst, then 62 + 62 + 1 bytes at 125 bytes/s
+		{"token", speedio.TokenBucket, time.Second, time.Second},
+		// 62 + 62 + 62 + 62 + 2 bytes at 125 bytes/s without burst
+		{"leaky", speedio.LeakyBucket, time.Millisecond * 1984, time.Millisecond * 1984},
+		// 125 bytes, then 62 + 62 + 1 bytes after the first 125 bytes
+		// leave the 1s window
+		{"sliding", speedio.SlidingLog, time.Second, time.Second},
+	}
+	for _, tc := range tcs {
+		clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+		conf := &speedio.LimiterConfig{
+			Resolution: time.Second,
+			MaxWait:    time.Millisecond * 500,
+			Clock:      clk,
+			Algorithm:  tc.algo,
+		}
+		w, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, 1000, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if et := writeFake(t, clk, w, 250); et != tc.first {
+			t.Errorf("%s: unexpected elapsed time: want: %s, got: %s", tc.name, tc.first, et)
+		}
+		clk.Advance(time.Second * 10)
+		if et := writeFake(t, clk, w, 250); et != tc.idle {
+			t.Errorf("%s: unexpected elapsed time after idle: want: %s, got: %s", tc.name, tc.idle, et)
+		}
+		_ = w.Close()
+	}
+}
+
+//
+func TestLimiterAlgorithm_opRate(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+		OpRate:     2,
+	}
+	w, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, 8000000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// 2 operations burst, and then 1 operation every 500ms.
+	var et time.Duration
+	for i := 0; i < 4; i++ {
+		et += writeFake(t, clk, w, 10)
+	}
+	if et != time.Second {
+		t.Errorf("unexpected elapsed time: want: 1s, got: %s", et)
+	}
+
+	// Combined with 1000 bit/s and 1 operation/s: 125 bytes burst, 62 bytes
+	// after 1s for the operation, and 63 bytes after another 1s.
+	clk.Advance(time.Second * 10)
+	conf.OpRate = 1
+	w, err = speedio.NewLimiterWriterWithConfig(ioutil.Discard, 1000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if et := writeFake(t, clk, w, 250); et != time.Second*2 {
+		t.Errorf("unexpected elapsed time: want: 2s, got: %s", et)
+	}
+
+	conf.OpRate = -1
+	if _, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, 1000, conf); err == nil {
+		t.Error("error expected")
+	}
+}
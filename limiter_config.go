@@ -18,9 +18,26 @@ import (
 //
 // MaxWait is the maximum waiting time when the transfer exceeds the bit rate.
 // After this MaxWait time elapses, only the portion that is allowed at that time is transferred.
+//
+// Clock is the source of the current time and timers. If it is nil, SystemClock is used.
+//
+// Ramp is the slow-start ramp of the bit rate. If it is nil, the bit rate is applied immediately.
+//
+// Algorithm is the limiting algorithm, such as TokenBucket, LeakyBucket and SlidingLog.
+// If it is nil, TokenBucket is used.
+//
+// OpRate is the maximum number of read or write operations on the underlying
+// io per second, in addition to the bit rate. Each operation must be allowed
+// by both limits. As with the bit rate, the operations not performed in the
+// previous Resolution period can be performed at once. If it is 0, the number
+// of operations is not limited.
 type LimiterConfig struct {
 	Resolution time.Duration
 	MaxWait    time.Duration
+	Clock      Clock
+	Ramp       *RampConfig
+	Algorithm  Algorithm
+	OpRate     float64 // operations per second
 }
 
 // DefaultLimiterConfig is the default configuration for bit rate limiting
@@ -0,0 +1,224 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// LimiterGroup implements bit rate limiting shared by multiple LimiterReader
+// and LimiterWriter objects. The total bit rate of all the members created by
+// the group is limited to the bit rate of the group.
+//
+// Groups can be nested to build a hierarchy of limits, such as global,
+// per-tenant and per-connection limits. A transfer by a member must be
+// allowed by its own limit, if any, and by the limits of all the ancestor
+// groups, and it waits for the most restrictive one.
+//
+// Members created by NewWeightedReader and NewWeightedWriter share the bit rate
+// of the group in proportion to their weights, so that a member that transfers
+// greedily cannot starve the others. The share of idle members is
+// redistributed to the active members. A new member is regarded as active for a
+// while after its creation, so that the members already transferring cannot
+// take its share before it starts, but an unused member does not keep its
+// share forever.
+type LimiterGroup struct {
+	lim   *limiter
+	share *shareGroup
+}
+
+// NewLimiterGroup creates a new LimiterGroup with default configuration.
+func NewLimiterGroup(rate infounit.BitRate) (*LimiterGroup, error) {
+	return NewLimiterGroupWithConfig(rate, nil)
+}
+
+// NewLimiterGroupWithConfig creates a new LimiterGroup with the specified
+// configuration. If conf is nil, the default configuration will be used.
+func NewLimiterGroupWithConfig(rate infounit.BitRate, conf *LimiterConfig) (*LimiterGroup, error) {
+	lim, err := newLimiterWithConfig(rate, conf)
+	if err != nil {
+		return nil, err
+	}
+	return newLimiterGroup(lim), nil
+}
+
+// newLimiterGroup creates a new LimiterGroup with the specified limiter.
+func newLimiterGroup(lim *limiter) *LimiterGroup {
+	return &LimiterGroup{lim: lim, share: newShareGroup(lim)}
+}
+
+// NewGroup creates a new LimiterGroup as a child of the group with default
+// configuration. The total bit rate of the members of the child group is
+// limited by both the child group and the group.
+func (g *LimiterGroup) NewGroup(rate infounit.BitRate) (*LimiterGroup, error) {
+	return g.NewGroupWithConfig(rate, nil)
+}
+
+// NewGroupWithConfig creates a new LimiterGroup as a child of the group with
+// the specified configuration. If conf is nil, the default configuration will
+// be used.
+func (g *LimiterGroup) NewGroupWithConfig(rate infounit.BitRate, conf *LimiterConfig) (*LimiterGroup, error) {
+	lim, err := g.newChildLimiter(rate, conf)
+	if err != nil {
+		return nil, err
+	}
+	return newLimiterGroup(lim), nil
+}
+
+// newChildLimiter creates a new limiter whose parent is the limiter of the group.
+func (g *LimiterGroup) newChildLimiter(rate infounit.BitRate, conf *LimiterConfig) (*limiter, error) {
+	lim, err := newLimiterWithConfig(rate, conf)
+	if err != nil {
+		return nil, err
+	}
+	lim.parent = g.lim
+	return lim, nil
+}
+
+// LimitingBitRate returns the current limiting bit rate of the group.
+func (g *LimiterGroup) LimitingBitRate() infounit.BitRate {
+	return g.lim.limitingBitRate(g.lim.clock.Now())
+}
+
+// ThrottledTime returns the sum of the times the members waited for the
+// limiting bit rate of the group or its members. The time paused by Pause is
+// not included.
+func (g *LimiterGroup) ThrottledTime() time.Duration {
+	return g.lim.waitedTime()
+}
+
+// SetBitRate sets a new limiting bit rate of the group. The new bit rate is
+// immediately applied to all the members.
+func (g *LimiterGroup) SetBitRate(rate infounit.BitRate) error {
+	return g.lim.setBitRate(g.lim.clock.Now(), rate)
+}
+
+// SetSchedule sets the schedule of the limiting bit rate. The bit rate is
+// switched at each boundary of the schedule. If s is nil, the schedule is
+// removed and the current bit rate is kept. SetBitRate also removes the
+// schedule. The schedule is applied to all the members.
+func (g *LimiterGroup) SetSchedule(s *Schedule) error {
+	return g.lim.setSchedule(g.lim.clock.Now(), s)
+}
+
+// SetTrace sets the bandwidth trace that drives the limiting bit rate, which
+// starts now. If loop is true, the trace restarts from the beginning at its
+// end. If t is nil, the trace is removed and the current bit rate is kept.
+// SetBitRate and SetSchedule also remove the trace. The trace is applied to all
+// the members.
+func (g *LimiterGroup) SetTrace(t *Trace, loop bool) error {
+	return g.lim.setTrace(g.lim.clock.Now(), t, loop)
+}
+
+// Pause pauses the transfers of all the members of the group and its child
+// groups. While paused, they block without consuming tokens until Resume is
+// called.
+func (g *LimiterGroup) Pause() {
+	g.lim.pause()
+}
+
+// Resume resumes the transfers paused by Pause.
+func (g *LimiterGroup) Resume() {
+	g.lim.resume()
+}
+
+// NewReader creates a new LimiterReader as a member of the group. Closing the
+// returned reader does not affect the other members.
+func (g *LimiterGroup) NewReader(rd io.Reader) *LimiterReader {
+	return newLimiterReader(rd, g.lim)
+}
+
+// NewWriter creates a new LimiterWriter as a member of the group. Closing the
+// returned writer does not affect the other members.
+func (g *LimiterGroup) NewWriter(wr io.Writer) *LimiterWriter {
+	return newLimiterWriter(wr, g.lim)
+}
+
+// NewLimiterReader creates a new LimiterReader as a member of the group with
+// its own limiting bit rate and default configuration. The reader is limited
+// by both the specified bit rate and the group. SetBitRate of the returned
+// reader only changes its own bit rate.
+func (g *LimiterGroup) NewLimiterReader(rd io.Reader, rate infounit.BitRate) (*LimiterReader, error) {
+	return g.NewLimiterReaderWithConfig(rd, rate, nil)
+}
+
+// NewLimiterReaderWithConfig is the same as NewLimiterReader except that it
+// uses the specified configuration. If conf is nil, the default configuration
+// will be used.
+func (g *LimiterGroup) NewLimiterReaderWithConfig(rd io.Reader, rate infounit.BitRate, conf *LimiterConfig) (*LimiterReader, error) {
+	lim, err := g.newChildLimiter(rate, conf)
+	if err != nil {
+		return nil, err
+	}
+	return newLimiterReader(rd, lim), nil
+}
+
+// NewLimiterWriter creates a new LimiterWriter as a member of the group with
+// its own limiting bit rate and default configuration. The writer is limited
+// by both the specified bit rate and the group. SetBitRate of the returned
+// writer only changes its own bit rate.
+func (g *LimiterGroup) NewLimiterWriter(wr io.Writer, rate infounit.BitRate) (*LimiterWriter, error) {
+	return g.NewLimiterWriterWithConfig(wr, rate, nil)
+}
+
+// NewLimiterWriterWithConfig is the same as NewLimiterWriter except that it
+// uses the specified configuration. If conf is nil, the default configuration
+// will be used.
+func (g *LimiterGroup) NewLimiterWriterWithConfig(wr io.Writer, rate infounit.BitRate, conf *LimiterConfig) (*LimiterWriter, error) {
+	lim, err := g.newChildLimiter(rate, conf)
+	if err != nil {
+		return nil, err
+	}
+	return newLimiterWriter(wr, lim), nil
+}
+
+// NewWeightedReader creates a new LimiterReader as a member of the group with
+// the specified weight. The reader is limited to the share of the bit rate of
+// the group allocated in proportion to the weight among the active weighted
+// members. LimitingBitRate of the returned reader returns the current share,
+// and SetBitRate of it is overwritten by the share at the next read.
+func (g *LimiterGroup) NewWeightedReader(rd io.Reader, weight float64) (*LimiterReader, error) {
+	lim, err := g.newWeightedLimiter(weight)
+	if err != nil {
+		return nil, err
+	}
+	return newLimiterReader(rd, lim), nil
+}
+
+// NewWeightedWriter creates a new LimiterWriter as a member of the group with
+// the specified weight. The writer is limited to the share of the bit rate of
+// the group allocated in proportion to the weight among the active weighted
+// members. LimitingBitRate of the returned writer returns the current share,
+// and SetBitRate of it is overwritten by the share at the next write.
+func (g *LimiterGroup) NewWeightedWriter(wr io.Writer, weight float64) (*LimiterWriter, error) {
+	lim, err := g.newWeightedLimiter(weight)
+	if err != nil {
+		return nil, err
+	}
+	return newLimiterWriter(wr, lim), nil
+}
+
+// newWeightedLimiter creates a new limiter whose bit rate is the share of the
+// group allocated to the specified weight.
+func (g *LimiterGroup) newWeightedLimiter(weight float64) (*limiter, error) {
+	if weight <= 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+		return nil, fmt.Errorf("%w: invalid weight %v", ErrInvalidParameter, weight)
+	}
+	g.lim.mu.RLock()
+	rate := g.lim.bitRate // non-zero even while the trace is stalled
+	g.lim.mu.RUnlock()
+	lim, err := newLimiterWithConfig(rate, g.lim.config())
+	if err != nil {
+		return nil, err
+	}
+	lim.parent = g.lim
+	lim.share = g.share.join(weight)
+	return lim, nil
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestLimiterGroup_test1(t *testing.T) {
+	t.Parallel()
+
+	// 4 writers share 16 kbit/s, 2 kbit burst in the first 1s resolution.
+	g, err := speedio.NewLimiterGroup(16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	tc := time.Now()
+	for i := 0; i < 4; i++ {
+		w := g.NewWriter(ioutil.Discard)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer w.Close()
+			if _, err := w.Write(make([]byte, 1000)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 4000 bytes = 32 kbit, 16 kbit of them are burst.
+	if et := time.Since(tc); et < time.Millisecond*900 {
+		t.Errorf("too fast: %s", et)
+	}
+	if got := g.NewWriter(ioutil.Discard).LimitingBitRate(); got != 16000 {
+		t.Errorf("unexpected member bit rate: %v", got)
+	}
+	if err := g.SetBitRate(32000); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.NewReader(nil).LimitingBitRate(); got != 32000 {
+		t.Errorf("unexpected member bit rate: %v", got)
+	}
+}
+
+//
+func TestLimiterGroup_test2(t *testing.T) {
+	t.Parallel()
+
+	if _, err := speedio.NewLimiterGroup(0); err == nil {
+		t.Errorf("error expected")
+	}
+}
+
+//
+func TestLimiterGroup_hierarchy(t *testing.T) {
+	t.Parallel()
+
+	global, err := speedio.NewLimiterGroup(1000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant, err := global.NewGroup(32000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tenant.NewLimiterWriter(ioutil.Discard, 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection limit 16 kbit/s is the most restrictive: 2000 bytes
+	// burst and 2000 bytes in the next 1s.
+	tc := time.Now()
+	n, err := w.Write(make([]byte, 4000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4000 {
+		t.Errorf("unexpected len: want: 4000, got: %d", n)
+	}
+	if et := time.Since(tc); et < time.Millisecond*900 {
+		t.Errorf("too fast: %s", et)
+	}
+	if got := w.LimitingBitRate(); got != 16000 {
+		t.Errorf("unexpected bit rate: %v", got)
+	}
+	if got := tenant.LimitingBitRate(); got != 32000 {
+		t.Errorf("unexpected tenant bit rate: %v", got)
+	}
+
+	// The tenant limit 16 kbit/s becomes the most restrictive: 2000 bytes
+	// burst and 1000 bytes in the next 500ms.
+	if err := tenant.SetBitRate(16000); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetBitRate(1000000); err != nil {
+		t.Fatal(err)
+	}
+	tc = time.Now()
+	if _, err := w.Write(make([]byte, 3000)); err != nil {
+		t.Fatal(err)
+	}
+	if et := time.Since(tc); et < time.Millisecond*400 {
+		t.Errorf("too fast: %s", et)
+	}
+}
+
+//
+func TestLimiterGroup_weighted(t *testing.T) {
+	t.Parallel()
+
+	// 32 kbit/s shared by the weights 1 and 3, 4000 bytes burst.
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	g, err := speedio.NewLimiterGroupWithConfig(32000, &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 100,
+		Clock:      clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	weights := []float64{1, 3}
+	ws := make([]*speedio.LimiterWriter, len(weights))
+	for i, weight := range weights {
+		if ws[i], err = g.NewWeightedWriter(ioutil.Discard, weight); err != nil {
+			t.Fatal(err)
+		}
+		defer ws[i].Close()
+	}
+
+	// Both write greedily every 100ms for 10s, the lighter one first.
+	sums := make([]int, len(weights))
+	for step := 0; step < 100; step++ {
+		for i, w := range ws {
+			for {
+				n, _, err := w.TryWrite(make([]byte, 100))
+				if errors.Is(err, speedio.ErrWouldBlock) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				sums[i] += n
+			}
+		}
+		if step == 0 && sums[1] < 3000 {
+			t.Errorf("new member starved: %d, %d", sums[0], sums[1])
+		}
+		clk.Advance(time.Millisecond * 100)
+	}
+	t.Logf("weight 1: %d bytes, weight 3: %d bytes", sums[0], sums[1])
+	if s0, s1 := sums[0], sums[1]; s0 == 0 || s1*10 < s0*27 || s0*33 < s1*10 {
+		t.Errorf("unfair share: %d, %d", s0, s1)
+	}
+	if total := sums[0] + sums[1]; 4000*10+4000 < total {
+		t.Errorf("group limit exceeded: %d bytes", total)
+	}
+}
+
+//
+func TestLimiterGroup_weightedUnused(t *testing.T) {
+	t.Parallel()
+
+	// 80 kbit/s shared by a busy member and a member never used.
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	g, err := speedio.NewLimiterGroupWithConfig(80000, &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 100,
+		Clock:      clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := g.NewWeightedWriter(ioutil.Discard, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	unused, err := g.NewWeightedWriter(ioutil.Discard, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unused.Close()
+
+	// The busy one writes greedily every 100ms for 5s. The unused one keeps
+	// its share only for the first second.
+	sum := 0
+	for step := 0; step < 50; step++ {
+		for {
+			n, _, err := busy.TryWrite(make([]byte, 100))
+			if errors.Is(err, speedio.ErrWouldBlock) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if 20 <= step {
+				sum += n
+			}
+		}
+		clk.Advance(time.Millisecond * 100)
+	}
+	t.Logf("busy member: %d bytes in the last 3s", sum)
+	if sum < 27000 || 30000+10000 < sum {
+		t.Errorf("share of the unused member not redistributed: %d bytes", sum)
+	}
+}
+
+// A member closed and then used gets the share as if it were still a member.
+func TestLimiterGroup_weightedClosed(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	g, err := speedio.NewLimiterGroupWithConfig(32000, &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 100,
+		Clock:      clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := g.NewWeightedWriter(ioutil.Discard, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write(make([]byte, 100))
+	if br := w.LimitingBitRate(); br != 32000 {
+		t.Errorf("unexpected limiting bit rate of the closed member: %v", br)
+	}
+}
+
+//
+func TestLimiterGroup_weightedInvalid(t *testing.T) {
+	t.Parallel()
+
+	g, err := speedio.NewLimiterGroup(32000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.NewWeightedReader(nil, 0); err == nil {
+		t.Errorf("error expected")
+	}
+}
+
+//
+func TestLimiterGroup_weightedStalled(t *testing.T) {
+	t.Parallel()
+
+	g, err := speedio.NewLimiterGroup(32000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &speedio.Trace{
+		Steps:    []speedio.TraceStep{{Offset: 0, BitRate: 0}},
+		Duration: time.Second,
+	}
+	if err := g.SetTrace(tr, false); err != nil {
+		t.Fatal(err)
+	}
+	if br := g.LimitingBitRate(); br != 0 {
+		t.Fatalf("unexpected limiting bit rate: %v", br)
+	}
+	w, err := g.NewWeightedWriter(ioutil.Discard, 1)
+	if err != nil {
+		t.Fatalf("weighted member of a stalled group: %v", err)
+	}
+	if br := w.LimitingBitRate(); br != 32000 {
+		t.Errorf("unexpected limiting bit rate of the member: %v", br)
+	}
+}
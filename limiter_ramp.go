@@ -0,0 +1,71 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// RampConfig indicates the configuration of the slow-start ramp of a limiter.
+// With the ramp, a new limiter starts at the fraction Initial of the bit rate,
+// and the limiting bit rate is increased to the bit rate over Duration along
+// Curve from its first transfer. Reading the limiting bit rate does not start
+// the ramp. The same ramp is applied when the bit rate is raised by SetBitRate or
+// a schedule, starting from the previous bit rate. Lowering the bit rate is
+// always applied immediately.
+type RampConfig struct {
+	Duration time.Duration
+	Initial  float64 // fraction of the bit rate, 0 < Initial <= 1
+	Curve    RampCurve
+}
+
+// RampCurve is the shape of the increase of the bit rate by the ramp.
+type RampCurve int
+
+const (
+	// RampLinear increases the bit rate linearly.
+	RampLinear RampCurve = iota
+
+	// RampExponential increases the bit rate exponentially, that is, it
+	// multiplies the bit rate by the same factor in the same period of time.
+	RampExponential
+)
+
+// check checks whether the ramp configuration is valid. nil is valid and
+// means no ramp.
+func (c *RampConfig) check() error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Duration <= 0:
+		return fmt.Errorf("%w: ramp duration %s <= 0", ErrInvalidParameter, c.Duration)
+	case !(0 < c.Initial && c.Initial <= 1):
+		return fmt.Errorf("%w: ramp initial fraction %v out of range", ErrInvalidParameter, c.Initial)
+	case c.Curve != RampLinear && c.Curve != RampExponential:
+		return fmt.Errorf("%w: unknown ramp curve %d", ErrInvalidParameter, c.Curve)
+	}
+	return nil
+}
+
+// at returns the bit rate of the ramp from the bit rate from to the bit rate to
+// at the elapsed time et since the ramp started. It also returns whether the
+// ramp is complete.
+func (c *RampConfig) at(from, to infounit.BitRate, et time.Duration) (infounit.BitRate, bool) {
+	if c.Duration <= et {
+		return to, true
+	}
+	if et <= 0 {
+		return from, false
+	}
+	p := float64(et) / float64(c.Duration)
+	if c.Curve == RampExponential {
+		return infounit.BitRate(float64(from) * math.Pow(float64(to)/float64(from), p)), false
+	}
+	return from + infounit.BitRate(float64(to-from)*p), false
+}
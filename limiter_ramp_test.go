@@ -0,0 +1,141 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestLimiterRamp_test1(t *testing.T) {
+	t.Parallel()
+
+	for _, curve := range []speedio.RampCurve{speedio.RampLinear, speedio.RampExponential} {
+		t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+		clk := fakeclock.New(t0)
+		conf := &speedio.LimiterConfig{
+			Resolution: time.Second,
+			MaxWait:    time.Millisecond * 500,
+			Clock:      clk,
+			Ramp: &speedio.RampConfig{
+				Duration: time.Second * 10,
+				Initial:  0.1,
+				Curve:    curve,
+			},
+		}
+		w, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, 80000, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(make([]byte, 1)); err != nil {
+			t.Fatal(err) // starts the ramp
+		}
+		half := infounit.BitRate(44000)
+		if curve == speedio.RampExponential {
+			half = infounit.BitRate(8000 * math.Sqrt(10))
+		}
+		tcs := []struct {
+			et   time.Duration
+			rate infounit.BitRate
+		}{
+			{0, 8000},
+			{time.Second * 5, half},
+			{time.Second * 10, 80000},
+			{time.Second * 20, 80000},
+		}
+		for _, tc := range tcs {
+			clk.Set(t0.Add(tc.et))
+			if got := w.LimitingBitRate(); math.Abs(float64(got-tc.rate)) > 0.001 {
+				t.Errorf("curve=%d, %s: unexpected bit rate: want: %v, got: %v", curve, tc.et, tc.rate, got)
+			}
+		}
+
+		// raising the bit rate starts the ramp from the current bit rate
+		if err := w.SetBitRate(160000); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.LimitingBitRate(); got != 80000 {
+			t.Errorf("curve=%d: unexpected bit rate: want: 80000, got: %v", curve, got)
+		}
+		clk.Advance(time.Second * 10)
+		if got := w.LimitingBitRate(); got != 160000 {
+			t.Errorf("curve=%d: unexpected bit rate: want: 160000, got: %v", curve, got)
+		}
+
+		// lowering is applied immediately
+		if err := w.SetBitRate(16000); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.LimitingBitRate(); got != 16000 {
+			t.Errorf("curve=%d: unexpected bit rate: want: 16000, got: %v", curve, got)
+		}
+	}
+}
+
+// Reading the limiting bit rate before the first transfer does not start the
+// ramp.
+func TestLimiterRamp_readBeforeWrite(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+		Ramp: &speedio.RampConfig{
+			Duration: time.Second * 10,
+			Initial:  0.1,
+		},
+	}
+	w, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, 80000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.LimitingBitRate(); got != 8000 {
+		t.Errorf("unexpected bit rate: want: 8000, got: %v", got)
+	}
+	clk.Advance(time.Minute)
+	if got := w.LimitingBitRate(); got != 8000 {
+		t.Errorf("ramp started by reading: want: 8000, got: %v", got)
+	}
+	if _, err := w.Write(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second * 10)
+	if got := w.LimitingBitRate(); got != 80000 {
+		t.Errorf("unexpected bit rate: want: 80000, got: %v", got)
+	}
+}
+
+//
+func TestLimiterRamp_invalid(t *testing.T) {
+	t.Parallel()
+
+	ramps := []*speedio.RampConfig{
+		{Duration: 0, Initial: 0.5},
+		{Duration: time.Second, Initial: 0},
+		{Duration: time.Second, Initial: 1.5},
+		{Duration: time.Second, Initial: 0.5, Curve: 99},
+	}
+	for i, ramp := range ramps {
+		conf := &speedio.LimiterConfig{
+			Resolution: time.Second,
+			MaxWait:    time.Millisecond * 500,
+			Ramp:       ramp,
+		}
+		if _, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, 80000, conf); !errors.Is(err, speedio.ErrInvalidParameter) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
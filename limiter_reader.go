@@ -5,6 +5,7 @@
 package speedio
 
 import (
+	"context"
 	"io"
 	"sync"
 	"time"
@@ -15,9 +16,6 @@ import (
 // LimiterReader implements bit rate limiting for an io.Reader object.
 type LimiterReader struct {
 	rd         io.Reader // underlying reader provided by the client
-	rate       infounit.BitRate
-	resolution time.Duration
-	maxWait    time.Duration
 	lim        *limiter
 	closed     bool
 	closedChan chan struct{}
@@ -35,40 +33,68 @@ func NewLimiterReader(rd io.Reader, rate infounit.BitRate) (*LimiterReader, erro
 // NewLimiterReaderWithConfig creates a new LimiterReader with the specified
 // configuration. If conf is nil, the default configuration will be used.
 func NewLimiterReaderWithConfig(rd io.Reader, rate infounit.BitRate, conf *LimiterConfig) (*LimiterReader, error) {
-	if conf == nil {
-		conf = DefaultLimiterConfig
+	lim, err := newLimiterWithConfig(rate, conf)
+	if err != nil {
+		return nil, err
 	}
-	r := &LimiterReader{
+	return newLimiterReader(rd, lim), nil
+}
+
+// newLimiterReader creates a new LimiterReader with the specified limiter,
+// which may be shared with other objects.
+func newLimiterReader(rd io.Reader, lim *limiter) *LimiterReader {
+	return &LimiterReader{
 		rd:         rd,
-		rate:       rate,
-		resolution: conf.Resolution,
-		maxWait:    conf.MaxWait,
+		lim:        lim,
 		closedChan: make(chan struct{}),
 	}
-	lim, err := newLimiter(r.rate, r.resolution, r.maxWait)
-	if err != nil {
-		return nil, err
-	}
-	r.lim = lim
-	return r, nil
 }
 
 // LimitingBitRate returns the current limiting bit rate.
 func (r *LimiterReader) LimitingBitRate() infounit.BitRate {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	return r.rate
+	return r.lim.limitingBitRate(r.lim.clock.Now())
+}
+
+// ThrottledTime returns the total time waited for the limiting bit rate. The
+// time paused by Pause is not included.
+func (r *LimiterReader) ThrottledTime() time.Duration {
+	return r.lim.waitedTime()
 }
 
-// SetBitRate sets a new limiting bit rate.
+// SetBitRate sets a new limiting bit rate. If the reader was created by a
+// LimiterGroup, the bit rate of the whole group is changed.
 func (r *LimiterReader) SetBitRate(rate infounit.BitRate) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	if err := r.lim.set(time.Now(), rate, r.resolution, r.maxWait); err != nil {
-		return err
-	}
-	r.rate = rate
-	return nil
+	return r.lim.setBitRate(r.lim.clock.Now(), rate)
+}
+
+// SetSchedule sets the schedule of the limiting bit rate. The bit rate is
+// switched at each boundary of the schedule. If s is nil, the schedule is
+// removed and the current bit rate is kept. SetBitRate also removes the
+// schedule. If the reader was created by a LimiterGroup, the schedule is applied
+// to the whole group.
+func (r *LimiterReader) SetSchedule(s *Schedule) error {
+	return r.lim.setSchedule(r.lim.clock.Now(), s)
+}
+
+// SetTrace sets the bandwidth trace that drives the limiting bit rate, which
+// starts now. If loop is true, the trace restarts from the beginning at its
+// end. If t is nil, the trace is removed and the current bit rate is kept.
+// SetBitRate and SetSchedule also remove the trace. If the reader was created
+// by a LimiterGroup, the trace is applied to the whole group.
+func (r *LimiterReader) SetTrace(t *Trace, loop bool) error {
+	return r.lim.setTrace(r.lim.clock.Now(), t, loop)
+}
+
+// Pause pauses the reader. While paused, Read blocks without consuming
+// tokens until Resume is called, the context is done, or the reader is closed.
+// If the reader was created by a LimiterGroup, the whole group is paused.
+func (r *LimiterReader) Pause() {
+	r.lim.pause()
+}
+
+// Resume resumes the reader paused by Pause.
+func (r *LimiterReader) Resume() {
+	r.lim.resume()
 }
 
 // Close closes the reader. If the underlying reader implements io.ReadCloser,
@@ -91,6 +117,7 @@ func (r *LimiterReader) close(chain bool) error {
 	}
 	r.closed = true
 	close(r.closedChan)
+	r.lim.leave()
 	if !chain {
 		return nil
 	}
@@ -105,18 +132,15 @@ func (r *LimiterReader) close(chain bool) error {
 // Read reads data from the underlying reader into p. This may return shorter
 // length than len(p). It may block for up to maxWait time.
 func (r *LimiterReader) Read(p []byte) (int, error) {
-	tc := time.Now()
-	wd, abc := r.lim.request(tc, len(p))
-	if 0 < wd {
-		timer := time.NewTimer(wd)
-		select {
-		case <-timer.C:
-		case <-r.closedChan:
-			if !timer.Stop() {
-				<-timer.C
-			}
-			return 0, ErrClosed
-		}
+	return r.ReadContext(context.Background(), p)
+}
+
+// ReadContext is the same as Read except that it stops waiting for the
+// limiter when ctx is done, and returns ctx.Err(). The reader remains usable.
+func (r *LimiterReader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	abc, err := r.lim.acquire(ctx, r.closedChan, len(p))
+	if err != nil {
+		return 0, err
 	}
 	n, err := r.rd.Read(p[:abc])
 	if n < abc {
@@ -124,3 +148,21 @@ func (r *LimiterReader) Read(p []byte) (int, error) {
 	}
 	return n, err
 }
+
+// TryRead is the same as Read except that it never blocks. It reads only the
+// data that the limiter allows immediately. If nothing is allowed, it returns
+// 0, the duration until the tokens are available, and ErrWouldBlock.
+func (r *LimiterReader) TryRead(p []byte) (int, time.Duration, error) {
+	if len(p) == 0 {
+		return 0, 0, nil
+	}
+	abc, wd, err := r.lim.tryAcquire(r.closedChan, len(p))
+	if err != nil {
+		return 0, wd, err
+	}
+	n, err := r.rd.Read(p[:abc])
+	if n < abc {
+		r.lim.refund(abc - n)
+	}
+	return n, 0, err
+}
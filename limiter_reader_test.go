@@ -5,11 +5,16 @@
 package speedio_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/tunabay/go-randdata"
 	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
 )
 
 //
@@ -53,3 +58,60 @@ func TestLimiterReader_test2(t *testing.T) {
 		return
 	}
 }
+
+//
+func TestLimiterReader_context(t *testing.T) {
+	t.Parallel()
+
+	r, err := speedio.NewLimiterReader(bytes.NewReader(make([]byte, 5000)), 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	buf := make([]byte, 2000)
+	if n, err := r.Read(buf); err != nil || n != 1000 {
+		t.Fatalf("unexpected result: n=%d, err=%v", n, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err := r.ReadContext(ctx, buf)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("unexpected len: want: 0, got: %d", n)
+	}
+}
+
+//
+func TestLimiterReader_tryRead(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	}
+	r, err := speedio.NewLimiterReaderWithConfig(bytes.NewReader(make([]byte, 2000)), 8000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1000 bytes burst at 1000 bytes/s.
+	buf := make([]byte, 1500)
+	n, wd, err := r.TryRead(buf)
+	if n != 1000 || wd != 0 || err != nil {
+		t.Errorf("unexpected result: want: 1000, 0s, nil, got: %d, %s, %v", n, wd, err)
+	}
+	n, wd, err = r.TryRead(buf)
+	if n != 0 || wd != time.Millisecond*500 || !errors.Is(err, speedio.ErrWouldBlock) {
+		t.Errorf("unexpected result: want: 0, 500ms, %v, got: %d, %s, %v", speedio.ErrWouldBlock, n, wd, err)
+	}
+	clk.Advance(time.Second)
+	n, _, err = r.TryRead(buf)
+	if n != 1000 || err != nil {
+		t.Errorf("unexpected result: want: 1000, nil, got: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// Limiter implements bit rate limiting for transfers that are not performed
+// through io.Reader or io.Writer, such as batches of datagrams or RPC frames.
+// The caller asks the Limiter for permission to transfer n bytes by Reserve,
+// Allow or Wait, and then performs the transfer by itself.
+//
+// Unlike LimiterReader and LimiterWriter, the Limiter never divides a transfer.
+// A transfer exceeding the bit rate waits until all of its bytes are allowed.
+type Limiter struct {
+	lim *limiter
+}
+
+// NewLimiter creates a new Limiter with default configuration.
+func NewLimiter(rate infounit.BitRate) (*Limiter, error) {
+	return NewLimiterWithConfig(rate, nil)
+}
+
+// NewLimiterWithConfig creates a new Limiter with the specified configuration.
+// If conf is nil, the default configuration will be used.
+func NewLimiterWithConfig(rate infounit.BitRate, conf *LimiterConfig) (*Limiter, error) {
+	lim, err := newLimiterWithConfig(rate, conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Limiter{lim: lim}, nil
+}
+
+// NewLimiter creates a new Limiter as a member of the group.
+func (g *LimiterGroup) NewLimiter() *Limiter {
+	return &Limiter{lim: g.lim}
+}
+
+// LimitingBitRate returns the current limiting bit rate.
+func (l *Limiter) LimitingBitRate() infounit.BitRate {
+	return l.lim.limitingBitRate(l.lim.clock.Now())
+}
+
+// ThrottledTime returns the total time waited by Wait for the limiting bit
+// rate. The time paused by Pause is not included.
+func (l *Limiter) ThrottledTime() time.Duration {
+	return l.lim.waitedTime()
+}
+
+// SetBitRate sets a new limiting bit rate. If the Limiter was created by a
+// LimiterGroup, the bit rate of the whole group is changed.
+func (l *Limiter) SetBitRate(rate infounit.BitRate) error {
+	return l.lim.setBitRate(l.lim.clock.Now(), rate)
+}
+
+// SetSchedule sets the schedule of the limiting bit rate. If s is nil, the
+// schedule is removed and the current bit rate is kept.
+func (l *Limiter) SetSchedule(s *Schedule) error {
+	return l.lim.setSchedule(l.lim.clock.Now(), s)
+}
+
+// SetTrace sets the bandwidth trace that drives the limiting bit rate, which
+// starts now. If loop is true, the trace restarts from the beginning at its
+// end. If t is nil, the trace is removed and the current bit rate is kept.
+// SetBitRate and SetSchedule also remove the trace.
+func (l *Limiter) SetTrace(t *Trace, loop bool) error {
+	return l.lim.setTrace(l.lim.clock.Now(), t, loop)
+}
+
+// Pause pauses the Limiter. While paused, Reserve returns a Reservation that
+// is not OK, Allow returns false, and Wait blocks until Resume is called.
+func (l *Limiter) Pause() {
+	l.lim.pause()
+}
+
+// Resume resumes the Limiter paused by Pause.
+func (l *Limiter) Resume() {
+	l.lim.resume()
+}
+
+// Reservation holds the tokens reserved by Reserve for a transfer.
+type Reservation struct {
+	lim       *limiter
+	ok        bool
+	bc, ops   int
+	timeToAct time.Time
+	cancelled bool
+	mu        sync.Mutex
+}
+
+// Reserve reserves a transfer of n bytes and returns a Reservation that tells
+// how long the caller must wait before the transfer. The tokens are taken
+// immediately, so the caller must call Cancel of the Reservation if it does
+// not perform the transfer. The transfer is regarded as one operation for
+// OpRate. The returned Reservation is not OK if n is negative, n exceeds the
+// burst size, which is the amount of data allowed in the resolution at the
+// current bit rate, or the Limiter is paused.
+func (l *Limiter) Reserve(n int) *Reservation {
+	r, _ := l.reserve(l.lim.clock.Now(), n)
+	return r
+}
+
+// reserve is the actual implementation of Reserve. It also returns the error
+// if n is invalid.
+func (l *Limiter) reserve(tc time.Time, n int) (*Reservation, error) {
+	r := &Reservation{lim: l.lim, timeToAct: tc}
+	if n < 0 {
+		return r, fmt.Errorf("%w: negative size %d", ErrInvalidParameter, n)
+	}
+	if l.lim.pausedChan() != nil {
+		return r, nil
+	}
+	wd, err := l.lim.reserve(tc, n)
+	if err != nil {
+		return r, err
+	}
+	r.ok, r.bc, r.timeToAct = true, n, tc.Add(wd)
+	if 0 < n {
+		r.ops = 1
+	}
+	return r, nil
+}
+
+// OK returns whether the Reservation is valid. If it is false, Delay returns 0
+// and Cancel does nothing.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns the duration for which the caller must wait before the
+// transfer. Zero means the transfer can be performed immediately.
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(r.lim.clock.Now())
+}
+
+// DelayFrom returns the duration for which the caller must wait before the
+// transfer, from tc.
+func (r *Reservation) DelayFrom(tc time.Time) time.Duration {
+	if !r.ok {
+		return 0
+	}
+	if d := r.timeToAct.Sub(tc); 0 < d {
+		return d
+	}
+	return 0
+}
+
+// Cancel returns the reserved tokens to the Limiter, which means that the
+// transfer will not be performed. Calling Cancel more than once does nothing.
+func (r *Reservation) Cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.ok || r.cancelled {
+		return
+	}
+	r.cancelled = true
+	r.lim.cancel(r.bc, r.ops)
+}
+
+// Allow reports whether a transfer of n bytes can be performed immediately. If
+// it returns true, the tokens are taken and the caller should perform the
+// transfer. Otherwise, no tokens are taken.
+func (l *Limiter) Allow(n int) bool {
+	tc := l.lim.clock.Now()
+	r, _ := l.reserve(tc, n)
+	if !r.ok {
+		return false
+	}
+	if 0 < r.DelayFrom(tc) {
+		r.Cancel()
+		return false
+	}
+	return true
+}
+
+// Wait blocks until a transfer of n bytes is allowed. While the Limiter is
+// paused, it waits until resumed. If ctx is done while waiting, the tokens are
+// returned to the Limiter and ctx.Err() is returned. If n is negative or
+// exceeds the burst size, it returns ErrInvalidParameter.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	r, err := l.reserve(l.lim.clock.Now(), n)
+	for err == nil && !r.ok {
+		if resumed := l.lim.pausedChan(); resumed != nil {
+			select {
+			case <-resumed:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		r, err = l.reserve(l.lim.clock.Now(), n)
+	}
+	if err != nil {
+		return err
+	}
+	tc := l.lim.clock.Now()
+	wd := r.DelayFrom(tc)
+	if wd <= 0 {
+		return nil
+	}
+	timer := l.lim.clock.NewTimer(wd)
+	select {
+	case <-timer.C():
+		l.lim.addWaited(l.lim.clock.Now().Sub(tc))
+		return nil
+	case <-ctx.Done():
+		if !timer.Stop() {
+			<-timer.C()
+		}
+		r.Cancel()
+		l.lim.addWaited(l.lim.clock.Now().Sub(tc))
+		return ctx.Err()
+	}
+}
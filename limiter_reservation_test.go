@@ -0,0 +1,143 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestLimiter_reserve(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	}
+	l, err := speedio.NewLimiterWithConfig(8000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1000 bytes burst at 1000 bytes/s.
+	if !l.Allow(1000) {
+		t.Error("burst not allowed")
+	}
+	if l.Allow(1) {
+		t.Error("allowed exceeding the burst")
+	}
+
+	r := l.Reserve(1000)
+	if !r.OK() {
+		t.Fatal("reservation not OK")
+	}
+	if d := r.Delay(); d != time.Second {
+		t.Errorf("unexpected delay: want: 1s, got: %s", d)
+	}
+	clk.Advance(time.Millisecond * 500)
+	if d := r.Delay(); d != time.Millisecond*500 {
+		t.Errorf("unexpected delay: want: 500ms, got: %s", d)
+	}
+
+	// The cancelled tokens are returned, so the 1000 bytes are available
+	// again after 1s.
+	clk.Advance(time.Millisecond * 500)
+	r.Cancel()
+	r.Cancel()
+	if !l.Allow(1000) {
+		t.Error("cancelled tokens not returned")
+	}
+
+	l.Pause()
+	if r := l.Reserve(1); r.OK() {
+		t.Error("reservation OK while paused")
+	}
+	l.Resume()
+	if r := l.Reserve(-1); r.OK() {
+		t.Error("reservation OK for negative size")
+	}
+
+	// A transfer exceeding the burst is never allowed.
+	if r := l.Reserve(1001); r.OK() {
+		t.Error("reservation OK exceeding the burst")
+	}
+	if err := l.Wait(context.Background(), 1001); !errors.Is(err, speedio.ErrInvalidParameter) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// A reservation is one operation, even if the limiter would divide it.
+func TestLimiter_reserveOpRate(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 100,
+		Clock:      clk,
+		OpRate:     2,
+	}
+	l, err := speedio.NewLimiterWithConfig(80000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if !l.Allow(5000) {
+			t.Errorf("#%d: not allowed", i)
+		}
+	}
+	if l.Allow(1) {
+		t.Error("allowed exceeding the operation rate")
+	}
+}
+
+//
+func TestLimiter_wait(t *testing.T) {
+	t.Parallel()
+
+	l, err := speedio.NewLimiter(8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := time.Now()
+	if err := l.Wait(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Wait(context.Background(), 200); err != nil {
+		t.Fatal(err)
+	}
+	if et := time.Since(tc); et < time.Millisecond*150 {
+		t.Errorf("too fast: %s", et)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	tc = time.Now()
+	if err := l.Wait(ctx, 1000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: want: %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if et := time.Since(tc); time.Millisecond*500 < et {
+		t.Errorf("not cancelled: %s", et)
+	}
+
+	l.Pause()
+	time.AfterFunc(time.Millisecond*100, l.Resume)
+	tc = time.Now()
+	if err := l.Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if et := time.Since(tc); et < time.Millisecond*100 {
+		t.Errorf("not paused: %s", et)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// shareGroup distributes the bit rate of a limiter among its weighted members
+// in proportion to their weights. Only the active members, which requested a
+// transfer or joined the group recently, are taken into account, so that the
+// share of the idle members is redistributed to the active members, while a new
+// member is not starved by the others just before its first request.
+type shareGroup struct {
+	lim     *limiter // limiter of the group
+	members map[*shareMember]struct{}
+	mu      sync.Mutex
+}
+
+// shareMember is a weighted member of the shareGroup.
+type shareMember struct {
+	grp        *shareGroup
+	weight     float64
+	lastActive time.Time // time of the last request, or of joining
+}
+
+// newShareGroup creates a shareGroup distributing the bit rate of lim.
+func newShareGroup(lim *limiter) *shareGroup {
+	return &shareGroup{
+		lim:     lim,
+		members: make(map[*shareMember]struct{}),
+	}
+}
+
+// join adds a new member with the specified weight. The member is regarded as
+// active from the time it joins, as if it had just requested a transfer.
+func (g *shareGroup) join(weight float64) *shareMember {
+	m := &shareMember{grp: g, weight: weight, lastActive: g.lim.clock.Now()}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.members[m] = struct{}{}
+	return m
+}
+
+// leave removes the member from the group.
+func (m *shareMember) leave() {
+	m.grp.mu.Lock()
+	defer m.grp.mu.Unlock()
+	delete(m.grp.members, m)
+}
+
+// share marks the member as active and returns the bit rate currently
+// allocated to the member. A member is active if it requested a transfer, or
+// joined the group, within the resolution or max-wait duration of the group,
+// whichever is longer.
+func (m *shareMember) share(tc time.Time) infounit.BitRate {
+	m.grp.lim.mu.Lock()
+	m.grp.lim.update(tc)
+	rate, window := m.grp.lim.bitRate, m.grp.lim.resolution
+	if window < m.grp.lim.maxWait {
+		window = m.grp.lim.maxWait
+	}
+	m.grp.lim.mu.Unlock()
+
+	m.grp.mu.Lock()
+	defer m.grp.mu.Unlock()
+	m.lastActive = tc
+	since := tc.Add(-window)
+	var sum float64
+	for o := range m.grp.members {
+		if o.lastActive.After(since) {
+			sum += o.weight
+		}
+	}
+	if _, ok := m.grp.members[m]; !ok {
+		sum += m.weight // left, but still requesting
+	}
+	return infounit.BitRate(float64(rate) * m.weight / sum)
+}
@@ -5,6 +5,7 @@
 package speedio
 
 import (
+	"context"
 	"io"
 	"sync"
 	"time"
@@ -15,12 +16,10 @@ import (
 // LimiterWriter implements bit rate limiting for an io.Writer object.
 type LimiterWriter struct {
 	wr         io.Writer // underlying writer provided by the client
-	rate       infounit.BitRate
-	resolution time.Duration
-	maxWait    time.Duration
 	lim        *limiter
 	closed     bool
 	closedChan chan struct{}
+	adapt      adaptive
 	mu         sync.RWMutex
 }
 
@@ -35,42 +34,111 @@ func NewLimiterWriter(wr io.Writer, rate infounit.BitRate) (*LimiterWriter, erro
 // NewLimiterWriterWithConfig creates a new LimiterWriter with the specified
 // configuration. If conf is nil, the default configuration will be used.
 func NewLimiterWriterWithConfig(wr io.Writer, rate infounit.BitRate, conf *LimiterConfig) (*LimiterWriter, error) {
-	if conf == nil {
-		conf = DefaultLimiterConfig
+	lim, err := newLimiterWithConfig(rate, conf)
+	if err != nil {
+		return nil, err
 	}
-	w := &LimiterWriter{
+	return newLimiterWriter(wr, lim), nil
+}
+
+// newLimiterWriter creates a new LimiterWriter with the specified limiter,
+// which may be shared with other objects.
+func newLimiterWriter(wr io.Writer, lim *limiter) *LimiterWriter {
+	return &LimiterWriter{
 		wr:         wr,
-		rate:       rate,
-		resolution: conf.Resolution,
-		maxWait:    conf.MaxWait,
+		lim:        lim,
 		closedChan: make(chan struct{}),
 	}
-	lim, err := newLimiter(w.rate, w.resolution, w.maxWait)
-	if err != nil {
-		return nil, err
-	}
-	w.lim = lim
-	return w, nil
 }
 
 // LimitingBitRate returns the current limiting bit rate.
 func (w *LimiterWriter) LimitingBitRate() infounit.BitRate {
-	w.mu.RLock()
-	defer w.mu.RUnlock()
-	return w.rate
+	return w.lim.limitingBitRate(w.lim.clock.Now())
 }
 
-// SetBitRate sets a new limiting bit rate.
+// ThrottledTime returns the total time waited for the limiting bit rate. The
+// time paused by Pause is not included.
+func (w *LimiterWriter) ThrottledTime() time.Duration {
+	return w.lim.waitedTime()
+}
+
+// SetBitRate sets a new limiting bit rate. If the writer was created by a
+// LimiterGroup, the bit rate of the whole group is changed.
 func (w *LimiterWriter) SetBitRate(rate infounit.BitRate) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	if err := w.lim.set(time.Now(), rate, w.resolution, w.maxWait); err != nil {
-		return err
+	return w.lim.setBitRate(w.lim.clock.Now(), rate)
+}
+
+// SetSchedule sets the schedule of the limiting bit rate. The bit rate is
+// switched at each boundary of the schedule. If s is nil, the schedule is
+// removed and the current bit rate is kept. SetBitRate also removes the
+// schedule. If the writer was created by a LimiterGroup, the schedule is applied
+// to the whole group.
+func (w *LimiterWriter) SetSchedule(s *Schedule) error {
+	return w.lim.setSchedule(w.lim.clock.Now(), s)
+}
+
+// SetTrace sets the bandwidth trace that drives the limiting bit rate, which
+// starts now. If loop is true, the trace restarts from the beginning at its
+// end. If t is nil, the trace is removed and the current bit rate is kept.
+// SetBitRate and SetSchedule also remove the trace. If the writer was created
+// by a LimiterGroup, the trace is applied to the whole group.
+func (w *LimiterWriter) SetTrace(t *Trace, loop bool) error {
+	return w.lim.setTrace(w.lim.clock.Now(), t, loop)
+}
+
+// Pause pauses the writer. While paused, Write blocks without consuming
+// tokens until Resume is called, the context is done, or the writer is closed.
+// If the writer was created by a LimiterGroup, the whole group is paused.
+func (w *LimiterWriter) Pause() {
+	w.lim.pause()
+}
+
+// Resume resumes the writer paused by Pause.
+func (w *LimiterWriter) Resume() {
+	w.lim.resume()
+}
+
+// SetAdaptive enables the adaptive rate control with the configuration. The
+// limiting bit rate is clamped into the range of the configuration, and then
+// changed by the control through the same path as SetBitRate, which removes
+// the schedule, if any. The current bit rate chosen by the control is returned
+// by LimitingBitRate. If conf is nil, the control is disabled and the current
+// bit rate is kept. If the writer was created by a LimiterGroup, the bit rate of
+// the whole group is controlled.
+func (w *LimiterWriter) SetAdaptive(conf *AdaptiveConfig) error {
+	w.lim.mu.RLock()
+	resolution, maxWait := w.lim.resolution, w.lim.maxWait
+	w.lim.mu.RUnlock()
+	if conf != nil {
+		if err := conf.check(resolution, maxWait); err != nil {
+			return err
+		}
+		c := *conf
+		conf = &c
+	}
+	tc := w.lim.clock.Now()
+	cur := w.lim.limitingBitRate(tc)
+	if rate := w.adapt.set(tc, conf, resolution, cur); rate != cur {
+		return w.lim.setBitRate(tc, rate)
 	}
-	w.rate = rate
 	return nil
 }
 
+// write writes p to the underlying writer, observing the write for the
+// adaptive rate control if enabled.
+func (w *LimiterWriter) write(p []byte) (int, error) {
+	if !w.adapt.enabled() {
+		return w.wr.Write(p)
+	}
+	start := w.lim.clock.Now()
+	n, err := w.wr.Write(p)
+	tc := w.lim.clock.Now()
+	if rate, ok := w.adapt.observe(tc, tc.Sub(start), err, w.lim.limitingBitRate(tc)); ok {
+		_ = w.lim.setBitRate(tc, rate)
+	}
+	return n, err
+}
+
 // Close closes the writer.
 // If the underlying writer implements io.WriteCloser, its Close method
 // is also called.
@@ -92,6 +160,7 @@ func (w *LimiterWriter) close(chain bool) error {
 	}
 	w.closed = true
 	close(w.closedChan)
+	w.lim.leave()
 	if !chain {
 		return nil
 	}
@@ -108,22 +177,20 @@ func (w *LimiterWriter) close(chain bool) error {
 // until all the data is written. It repeatedly writes part of the divided p
 // to the underlying writer.
 func (w *LimiterWriter) Write(p []byte) (int, error) {
+	return w.WriteContext(context.Background(), p)
+}
+
+// WriteContext is the same as Write except that it stops waiting for the
+// limiter when ctx is done, and returns the number of bytes already written
+// and ctx.Err(). The writer remains usable.
+func (w *LimiterWriter) WriteContext(ctx context.Context, p []byte) (int, error) {
 	written := 0
 	for 0 < len(p) {
-		tc := time.Now()
-		wd, abc := w.lim.request(tc, len(p))
-		if 0 < wd {
-			timer := time.NewTimer(wd)
-			select {
-			case <-timer.C:
-			case <-w.closedChan:
-				if !timer.Stop() {
-					<-timer.C
-				}
-				return written, ErrClosed
-			}
+		abc, err := w.lim.acquire(ctx, w.closedChan, len(p))
+		if err != nil {
+			return written, err
 		}
-		n, err := w.wr.Write(p[:abc])
+		n, err := w.write(p[:abc])
 		if n < abc {
 			w.lim.refund(abc - n)
 		}
@@ -138,3 +205,25 @@ func (w *LimiterWriter) Write(p []byte) (int, error) {
 	}
 	return written, nil
 }
+
+// TryWrite is the same as Write except that it never blocks. It writes only
+// the part of p that the limiter allows immediately, and returns the number of
+// bytes written. If nothing is allowed, it returns 0, the duration until the
+// tokens are available, and ErrWouldBlock.
+func (w *LimiterWriter) TryWrite(p []byte) (int, time.Duration, error) {
+	if len(p) == 0 {
+		return 0, 0, nil
+	}
+	abc, wd, err := w.lim.tryAcquire(w.closedChan, len(p))
+	if err != nil {
+		return 0, wd, err
+	}
+	n, err := w.write(p[:abc])
+	if n < abc {
+		w.lim.refund(abc - n)
+	}
+	if err == nil && n == 0 {
+		err = ErrZeroWrite
+	}
+	return n, 0, err
+}
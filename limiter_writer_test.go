@@ -5,10 +5,14 @@
 package speedio_test
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
 )
 
 //
@@ -42,3 +46,181 @@ func TestLimiterWriter_test2(t *testing.T) {
 		t.Errorf("error expected")
 	}
 }
+
+//
+func TestLimiterWriter_context(t *testing.T) {
+	t.Parallel()
+
+	w, err := speedio.NewLimiterWriter(ioutil.Discard, 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// 1000 bytes burst, and then 500 bytes every 500ms.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	n, err := w.WriteContext(ctx, make([]byte, 2000))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n != 1000 {
+		t.Errorf("unexpected len: want: 1000, got: %d", n)
+	}
+
+	// The tokens for the cancelled 500 bytes must have been returned.
+	time.Sleep(time.Millisecond * 350)
+	tc := time.Now()
+	if _, err := w.Write(make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	if et := time.Since(tc); time.Millisecond*100 < et {
+		t.Errorf("too slow: %s", et)
+	}
+}
+
+//
+func TestLimiterWriter_fakeClock(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	}
+	w, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, 1000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// 125 bytes burst, and then 62 + 62 + 1 bytes at 125 bytes/s.
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		n, err := w.Write(make([]byte, 250))
+		done <- result{n, err}
+	}()
+	for i := 0; i < 3; i++ {
+		clk.BlockUntil(1)
+		next, _ := clk.Next()
+		clk.Set(next)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.n != 250 {
+		t.Errorf("unexpected len: want: 250, got: %d", res.n)
+	}
+	if et := clk.Now().Sub(t0); et != time.Second {
+		t.Errorf("unexpected elapsed time: want: 1s, got: %s", et)
+	}
+}
+
+//
+func TestLimiterWriter_pause(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	}
+	w, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, 80000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The paused write does not wait for the clock, and is not completed
+	// even if the clock advances.
+	w.Pause()
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 100))
+		done <- err
+	}()
+	clk.Advance(time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("not paused: %v", err)
+	default:
+	}
+	if n := clk.Pending(); n != 0 {
+		t.Errorf("paused write waits for the clock: %d timers", n)
+	}
+
+	// The tokens are not consumed while paused, so the burst is still
+	// available after resumed.
+	w.Resume()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	n, wd, err := w.TryWrite(make([]byte, 9900))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 9900 || wd != 0 {
+		t.Errorf("burst not available: %d bytes, %s", n, wd)
+	}
+
+	// Close unblocks the paused write.
+	w.Pause()
+	go func() {
+		_, err := w.Write(make([]byte, 100))
+		done <- err
+	}()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; !errors.Is(err, speedio.ErrClosed) {
+		t.Errorf("unexpected error: want: %v, got: %v", speedio.ErrClosed, err)
+	}
+}
+
+//
+func TestLimiterWriter_tryWrite(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	}
+	w, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, 8000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1000 bytes burst at 1000 bytes/s.
+	n, wd, err := w.TryWrite(make([]byte, 1500))
+	if n != 1000 || wd != 0 || err != nil {
+		t.Errorf("unexpected result: want: 1000, 0s, nil, got: %d, %s, %v", n, wd, err)
+	}
+	n, wd, err = w.TryWrite(make([]byte, 100))
+	if n != 0 || wd != time.Millisecond*100 || !errors.Is(err, speedio.ErrWouldBlock) {
+		t.Errorf("unexpected result: want: 0, 100ms, %v, got: %d, %s, %v", speedio.ErrWouldBlock, n, wd, err)
+	}
+
+	// The tokens of the blocked write are not consumed.
+	clk.Advance(time.Millisecond * 100)
+	n, wd, err = w.TryWrite(make([]byte, 100))
+	if n != 100 || wd != 0 || err != nil {
+		t.Errorf("unexpected result: want: 100, 0s, nil, got: %d, %s, %v", n, wd, err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := w.TryWrite(make([]byte, 1)); !errors.Is(err, speedio.ErrClosed) {
+		t.Errorf("unexpected error: want: %v, got: %v", speedio.ErrClosed, err)
+	}
+}
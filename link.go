@@ -0,0 +1,419 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// LinkConn is an end of an emulated link created by NewLink. It implements
+// net.Conn. The data written to an end arrives at the other end after the
+// serialization delay at the bandwidth plus the propagation delay, which is
+// the latency with a random jitter. The data always arrives in order.
+type LinkConn struct {
+	in, out *linkPipe
+	rdl     *deadline
+	wdl     *deadline
+	closed  bool
+	mu      sync.Mutex
+}
+
+// LinkConfig indicates the configuration parameters of an emulated link, which
+// are applied to both directions.
+//
+// Limiter is the configuration of the limiter emulating the bandwidth. If nil,
+// the leaky bucket algorithm with a max-wait of 10ms is used, so that the data
+// is serialized at the bandwidth in chunks of 10ms without bursts. Its Clock is
+// used for the whole link, including the latency and the measurement.
+//
+// Meter is the configuration of the meters measuring the data arriving at each
+// end. If nil, the default configuration will be used.
+//
+// Seed is the seed of the random jitter. The two directions use Seed and
+// Seed+1. If zero, the current time of the Clock is used, so that the jitter
+// is reproducible with a fake clock.
+type LinkConfig struct {
+	Limiter *LimiterConfig
+	Meter   *MeterConfig
+	Seed    int64
+}
+
+// clock returns the clock of the link.
+func (conf *LinkConfig) clock() Clock {
+	if conf.Limiter != nil && conf.Limiter.Clock != nil {
+		return conf.Limiter.Clock
+	}
+	return SystemClock
+}
+
+// defaultLinkLimiterConfig is the default configuration of the limiter of a
+// link.
+var defaultLinkLimiterConfig = &LimiterConfig{
+	Resolution: time.Second,
+	MaxWait:    time.Millisecond * 10,
+	Algorithm:  LeakyBucket,
+}
+
+// NewLink creates an emulated link with default configuration, and returns its
+// two ends, like net.Pipe. A zero bandwidth means no limit. The jitter is the
+// maximum random deviation added to or subtracted from the latency. The
+// propagation delay is zero if the jitter subtracts more than the latency.
+func NewLink(bandwidth infounit.BitRate, latency, jitter time.Duration) (*LinkConn, *LinkConn, error) {
+	return NewLinkWithConfig(bandwidth, latency, jitter, nil)
+}
+
+// NewLinkWithConfig creates an emulated link with the specified configuration.
+// If conf is nil, the default configuration will be used.
+func NewLinkWithConfig(bandwidth infounit.BitRate, latency, jitter time.Duration, conf *LinkConfig) (*LinkConn, *LinkConn, error) {
+	if conf == nil {
+		conf = &LinkConfig{}
+	}
+	switch {
+	case latency < 0:
+		return nil, nil, fmt.Errorf("%w: negative latency %s", ErrInvalidParameter, latency)
+	case jitter < 0:
+		return nil, nil, fmt.Errorf("%w: negative jitter %s", ErrInvalidParameter, jitter)
+	}
+	seed := conf.Seed
+	if seed == 0 {
+		seed = conf.clock().Now().UnixNano()
+	}
+	a2b, err := newLinkPipe(bandwidth, latency, jitter, seed, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	b2a, err := newLinkPipe(bandwidth, latency, jitter, seed+1, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	a := &LinkConn{in: b2a, out: a2b, rdl: newDeadline(b2a.clock), wdl: newDeadline(a2b.clock)}
+	b := &LinkConn{in: a2b, out: b2a, rdl: newDeadline(a2b.clock), wdl: newDeadline(b2a.clock)}
+	return a, b, nil
+}
+
+// Read reads data arrived from the other end. It blocks until any data
+// arrives, or until the read deadline, when it returns an error wrapping
+// os.ErrDeadlineExceeded as net.Pipe does. It returns io.EOF after all the data
+// written before the other end is closed has been read.
+func (c *LinkConn) Read(p []byte) (int, error) {
+	return c.in.read(c.rdl.done(), p)
+}
+
+// Write writes data to the link. It blocks until all the data in p is sent at
+// the bandwidth, or until the write deadline. It does not wait for the data to
+// arrive at the other end.
+func (c *LinkConn) Write(p []byte) (int, error) {
+	return c.out.write(c.wdl.context(), p)
+}
+
+// Close closes the end of the link. The other end can still read the data
+// already written, and then gets io.EOF. Any blocked Read or Write operations
+// on this end, and Write operations on the other end, are unblocked and return
+// io.ErrClosedPipe. The data written by the other end and still in flight is
+// discarded.
+func (c *LinkConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.rdl.stop()
+	c.wdl.stop()
+	c.out.closeWrite()
+	c.in.closeRead()
+	return nil
+}
+
+// LocalAddr returns the local network address.
+func (c *LinkConn) LocalAddr() net.Addr { return linkAddr{} }
+
+// RemoteAddr returns the remote network address.
+func (c *LinkConn) RemoteAddr() net.Addr { return linkAddr{} }
+
+// SetDeadline sets the read and write deadlines.
+func (c *LinkConn) SetDeadline(t time.Time) error {
+	c.rdl.set(t)
+	c.wdl.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *LinkConn) SetReadDeadline(t time.Time) error {
+	c.rdl.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline.
+func (c *LinkConn) SetWriteDeadline(t time.Time) error {
+	c.wdl.set(t)
+	return nil
+}
+
+// ReadBitRate returns the bit rate of the data arriving at this end in the
+// most recent sampling period.
+func (c *LinkConn) ReadBitRate() infounit.BitRate {
+	return c.in.met.bitRate(c.in.clock.Now())
+}
+
+// WriteBitRate returns the bit rate of the data written by this end and
+// arriving at the other end in the most recent sampling period.
+func (c *LinkConn) WriteBitRate() infounit.BitRate {
+	return c.out.met.bitRate(c.out.clock.Now())
+}
+
+// ReadTotal returns the data transfer amount, elapsed time, and bit rate of
+// the data arriving at this end in the entire period from start.
+func (c *LinkConn) ReadTotal() (infounit.ByteCount, time.Duration, infounit.BitRate) {
+	return c.in.met.total(c.in.clock.Now())
+}
+
+// WriteTotal returns the data transfer amount, elapsed time, and bit rate of
+// the data written by this end and arriving at the other end in the entire
+// period from start.
+func (c *LinkConn) WriteTotal() (infounit.ByteCount, time.Duration, infounit.BitRate) {
+	return c.out.met.total(c.out.clock.Now())
+}
+
+// linkAddr is the address of both ends of a link.
+type linkAddr struct{}
+
+func (linkAddr) Network() string { return "link" }
+func (linkAddr) String() string  { return "link" }
+
+// linkPipe is a direction of a link.
+type linkPipe struct {
+	lim         *limiter // nil if not limited
+	met         *meter
+	clock       Clock
+	latency     time.Duration
+	jitter      time.Duration
+	rand        *rand.Rand
+	queue       []linkSegment
+	last        time.Time     // arrival time of the last segment
+	notify      chan struct{} // closed when a segment is queued or closed
+	done        chan struct{} // closed when either end is closed
+	writeClosed bool
+	readClosed  bool
+	mu          sync.Mutex
+}
+
+// linkSegment is data in flight on a link.
+type linkSegment struct {
+	data []byte
+	at   time.Time // arrival time
+}
+
+// newLinkPipe creates a direction of a link, whose jitter is generated from
+// the seed.
+func newLinkPipe(bandwidth infounit.BitRate, latency, jitter time.Duration, seed int64, conf *LinkConfig) (*linkPipe, error) {
+	lconf := conf.Limiter
+	if lconf == nil {
+		lconf = defaultLinkLimiterConfig
+	}
+	mconf := conf.Meter
+	if mconf == nil {
+		mconf = DefaultMeterConfig
+	}
+	p := &linkPipe{
+		clock:   conf.clock(),
+		latency: latency,
+		jitter:  jitter,
+		rand:    rand.New(rand.NewSource(seed)),
+		notify:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if bandwidth != 0 {
+		lim, err := newLimiterWithConfig(bandwidth, lconf)
+		if err != nil {
+			return nil, err
+		}
+		p.lim = lim
+	}
+	met, err := newMeterWithConfig(mconf)
+	if err != nil {
+		return nil, err
+	}
+	p.met = met
+	return p, nil
+}
+
+// write sends the data in chunks allowed by the limiter, and queues them with
+// their arrival times.
+func (p *linkPipe) write(ctx context.Context, b []byte) (int, error) {
+	written := 0
+	for 0 < len(b) {
+		if isClosedChan(p.done) {
+			return written, io.ErrClosedPipe
+		}
+		n := len(b)
+		var ser time.Duration
+		if p.lim != nil {
+			abc, err := p.lim.acquire(ctx, p.done, len(b))
+			switch {
+			case errors.Is(err, ErrClosed):
+				return written, io.ErrClosedPipe
+			case err != nil:
+				return written, err
+			}
+			n = abc
+			p.lim.mu.RLock()
+			ser = time.Duration(float64(n) * bpscoef / float64(p.lim.bitRate))
+			p.lim.mu.RUnlock()
+		} else if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		if !p.push(b[:n], ser) {
+			return written, io.ErrClosedPipe
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// push queues a copy of the data, which arrives after the serialization delay
+// ser and the latency with the jitter. The latency with the jitter is clamped at
+// zero, so that the data never arrives before ser. It returns false if the pipe
+// is closed.
+func (p *linkPipe) push(b []byte, ser time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writeClosed || p.readClosed {
+		return false
+	}
+	prop := p.latency
+	if 0 < p.jitter {
+		prop += time.Duration(p.rand.Int63n(int64(p.jitter)*2+1)) - p.jitter
+		if prop < 0 {
+			prop = 0
+		}
+	}
+	at := p.clock.Now().Add(ser + prop)
+	if at.Before(p.last) {
+		at = p.last
+	}
+	p.last = at
+	p.queue = append(p.queue, linkSegment{data: append([]byte(nil), b...), at: at})
+	p.wake()
+	return true
+}
+
+// wake wakes up the reader waiting for the pipe. It must be called with p.mu
+// held.
+func (p *linkPipe) wake() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+// read reads the data that has arrived, waiting until any data arrives or
+// deadline is closed.
+func (p *linkPipe) read(deadline <-chan struct{}, b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for {
+		n, wait, notify, err := p.pop(b)
+		if n != 0 || err != nil {
+			return n, err
+		}
+		if isClosedChan(deadline) {
+			return 0, errTimeout
+		}
+		if wait <= 0 {
+			select {
+			case <-notify:
+			case <-deadline:
+			}
+			continue
+		}
+		timer := p.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-notify:
+			timer.Stop()
+		case <-deadline:
+			timer.Stop()
+		}
+	}
+}
+
+// pop reads the data that has arrived. If nothing has arrived, it returns the
+// duration until the next segment arrives, or 0 if none is in flight, and the
+// channel notified when the pipe is changed.
+func (p *linkPipe) pop(b []byte) (int, time.Duration, <-chan struct{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.readClosed {
+		return 0, 0, nil, io.ErrClosedPipe
+	}
+	tc := p.clock.Now()
+	n := 0
+	for 0 < len(p.queue) && n < len(b) && !p.queue[0].at.After(tc) {
+		seg := &p.queue[0]
+		c := copy(b[n:], seg.data)
+		n += c
+		if seg.data = seg.data[c:]; len(seg.data) == 0 {
+			p.queue[0] = linkSegment{}
+			p.queue = p.queue[1:]
+		}
+	}
+	if 0 < n {
+		p.met.start(tc)
+		p.met.record(tc, infounit.ByteCount(n))
+		return n, 0, nil, nil
+	}
+	switch {
+	case len(b) == 0:
+		return 0, 0, nil, nil
+	case 0 < len(p.queue):
+		return 0, p.queue[0].at.Sub(tc), p.notify, nil
+	case p.writeClosed:
+		p.met.close(tc)
+		return 0, 0, nil, io.EOF
+	}
+	return 0, 0, p.notify, nil
+}
+
+// closeWrite closes the writing end of the pipe. The data in flight still
+// arrives.
+func (p *linkPipe) closeWrite() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writeClosed {
+		return
+	}
+	p.writeClosed = true
+	if !p.readClosed {
+		close(p.done)
+	}
+	p.wake()
+}
+
+// closeRead closes the reading end of the pipe. The data in flight is
+// discarded.
+func (p *linkPipe) closeRead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.readClosed {
+		return
+	}
+	p.readClosed = true
+	p.queue = nil
+	if !p.writeClosed {
+		close(p.done)
+	}
+	p.met.close(p.clock.Now())
+	p.wake()
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestLink_latency(t *testing.T) {
+	t.Parallel()
+
+	a, b, err := speedio.NewLink(0, time.Millisecond*100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	defer b.Close()
+
+	tc := time.Now()
+	if _, err := a.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if et := time.Since(tc); time.Millisecond*50 < et {
+		t.Errorf("write waits for arrival: %s", et)
+	}
+	buf := make([]byte, 16)
+	n, err := b.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("unexpected data: %q", buf[:n])
+	}
+	if et := time.Since(tc); et < time.Millisecond*100 || time.Millisecond*300 < et {
+		t.Errorf("unexpected latency: %s", et)
+	}
+}
+
+//
+func TestLink_bandwidth(t *testing.T) {
+	t.Parallel()
+
+	// 5000 bytes at 10000 bytes/s, and then 50ms +/- 10ms.
+	a, b, err := speedio.NewLink(80000, time.Millisecond*50, time.Millisecond*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	src := make([]byte, 5000)
+	for i := range src {
+		src[i] = byte(i)
+	}
+	tc := time.Now()
+	go func() {
+		if _, err := a.Write(src); err != nil {
+			t.Error(err)
+		}
+		_ = a.Close()
+	}()
+	dst, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	et := time.Since(tc)
+	if len(dst) != len(src) {
+		t.Fatalf("unexpected len: want: %d, got: %d", len(src), len(dst))
+	}
+	for i := range src {
+		if src[i] != dst[i] {
+			t.Fatalf("data mismatch at %d", i)
+		}
+	}
+	if et < time.Millisecond*540 || time.Millisecond*900 < et {
+		t.Errorf("unexpected elapsed time: %s", et)
+	}
+	if bc, _, _ := b.ReadTotal(); bc != 5000 {
+		t.Errorf("unexpected read total: %v", bc)
+	}
+	if bc, _, _ := a.WriteTotal(); bc != 5000 {
+		t.Errorf("unexpected write total: %v", bc)
+	}
+}
+
+//
+func TestLink_close(t *testing.T) {
+	t.Parallel()
+
+	a, b, err := speedio.NewLink(0, time.Millisecond*10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The read deadline ends the read with a timeout error.
+	if err := b.SetReadDeadline(time.Now().Add(time.Millisecond * 50)); err != nil {
+		t.Fatal(err)
+	}
+	var nerr net.Error
+	if _, err := b.Read(make([]byte, 1)); !errors.As(err, &nerr) || !nerr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := b.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The data in flight arrives after the writer is closed.
+	if _, err := a.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("unexpected error: want: %v, got: %v", io.ErrClosedPipe, err)
+	}
+	if _, err := b.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("unexpected error: want: %v, got: %v", io.ErrClosedPipe, err)
+	}
+	buf := make([]byte, 16)
+	n, err := io.ReadFull(b, buf)
+	if string(buf[:n]) != "bye" || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("unexpected result: %q, %v", buf[:n], err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Read(buf); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("unexpected error: want: %v, got: %v", io.ErrClosedPipe, err)
+	}
+}
+
+// An empty read returns immediately, and the deadlines are timed by the clock
+// of the link.
+func TestLink_deadlineFakeClock(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	conf := &speedio.LinkConfig{
+		Limiter: &speedio.LimiterConfig{
+			Resolution: time.Second,
+			MaxWait:    time.Millisecond * 10,
+			Clock:      clk,
+		},
+	}
+	a, b, err := speedio.NewLinkWithConfig(0, time.Millisecond*100, 0, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	defer b.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Read(nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("empty read blocks")
+	}
+
+	if err := b.SetReadDeadline(t0.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, err := b.Read(make([]byte, 1))
+		done <- err
+	}()
+	clk.BlockUntil(1) // the deadline
+	select {
+	case err := <-done:
+		t.Fatalf("read returned before the deadline: %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+	clk.Advance(time.Second)
+	var nerr net.Error
+	if err := <-done; !errors.As(err, &nerr) || !nerr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// The jitter is reproducible with the seed or the fake clock.
+func TestLink_jitterSeed(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	arrivals := func(seed int64) []time.Duration {
+		clk := fakeclock.New(t0)
+		conf := &speedio.LinkConfig{
+			Limiter: &speedio.LimiterConfig{
+				Resolution: time.Second,
+				MaxWait:    time.Millisecond * 10,
+				Clock:      clk,
+			},
+			Seed: seed,
+		}
+		a, b, err := speedio.NewLinkWithConfig(0, time.Millisecond*100, time.Millisecond*50, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer a.Close()
+		defer b.Close()
+
+		// Each byte is sent after the previous one arrives.
+		var ats []time.Duration
+		done := make(chan error)
+		for i := 0; i < 5; i++ {
+			if _, err := a.Write([]byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+			go func() {
+				_, err := b.Read(make([]byte, 1))
+				done <- err
+			}()
+			clk.BlockUntil(1)
+			at, _ := clk.Next()
+			clk.Set(at)
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+			ats = append(ats, at.Sub(t0))
+		}
+		return ats
+	}
+	for _, seed := range []int64{0, 1} {
+		want, got := arrivals(seed), arrivals(seed)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("seed %d: arrivals not reproducible: %v, %v", seed, want, got)
+		}
+	}
+	if a0, a1 := arrivals(0), arrivals(1); reflect.DeepEqual(a0, a1) {
+		t.Errorf("same arrivals with different seeds: %v", a0)
+	}
+}
+
+// The data does not arrive before the serialization delay even if the jitter
+// exceeds the latency.
+func TestLink_jitterExceedsLatency(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	for seed := int64(1); seed <= 20; seed++ {
+		clk := fakeclock.New(t0)
+		conf := &speedio.LinkConfig{
+			Limiter: &speedio.LimiterConfig{
+				Resolution: time.Second,
+				MaxWait:    time.Millisecond * 10,
+				Algorithm:  speedio.LeakyBucket,
+				Clock:      clk,
+			},
+			Seed: seed,
+		}
+		// 1250 bytes at 1 Mbit/s take 10ms, and then 0 +/- 50ms.
+		a, b, err := speedio.NewLinkWithConfig(1000000, 0, time.Millisecond*50, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.Write(make([]byte, 1250)); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.SetReadDeadline(t0); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := b.Read(make([]byte, 1250)); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("seed %d: %d bytes arrived at the write: %v", seed, n, err)
+			a.Close()
+			b.Close()
+			continue
+		}
+		if err := b.SetReadDeadline(time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error)
+		go func() {
+			_, err := b.Read(make([]byte, 1250))
+			done <- err
+		}()
+		clk.BlockUntil(1)
+		at, _ := clk.Next()
+		clk.Set(at)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if d := at.Sub(t0); d < time.Millisecond*10 || time.Millisecond*60 < d {
+			t.Errorf("seed %d: unexpected arrival: %s", seed, d)
+		}
+		a.Close()
+		b.Close()
+	}
+}
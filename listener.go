@@ -0,0 +1,168 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// Listener implements a net.Listener that wraps every accepted connection in a
+// Conn. Each connection is limited to the per-connection bit rate in each
+// direction, and all the connections share the total bit rate in each
+// direction. The listener also measures the aggregate bit rate of all the
+// connections.
+type Listener struct {
+	ln         net.Listener // underlying listener provided by the client
+	rate       infounit.BitRate
+	conf       ConnConfig
+	rlim, wlim *LimiterGroup
+	rmet, wmet *MeterGroup
+	conns      map[*Conn]struct{}
+	mu         sync.Mutex
+}
+
+// NewListener creates a new Listener with default configurations.
+// perConnRate is the limiting bit rate of each connection, and totalRate is the
+// limiting bit rate of all the connections, both for each direction.
+func NewListener(ln net.Listener, perConnRate, totalRate infounit.BitRate) (*Listener, error) {
+	return NewListenerWithConfig(ln, perConnRate, totalRate, nil)
+}
+
+// NewListenerWithConfig creates a new Listener with the specified
+// configurations. If conf is nil, the default configurations will be used.
+// The configurations are used for both each connection and the aggregate.
+func NewListenerWithConfig(ln net.Listener, perConnRate, totalRate infounit.BitRate, conf *ConnConfig) (*Listener, error) {
+	if conf == nil {
+		conf = &ConnConfig{}
+	}
+	l := &Listener{
+		ln:    ln,
+		rate:  perConnRate,
+		conf:  *conf,
+		conns: make(map[*Conn]struct{}),
+	}
+	var err error
+	if l.rlim, err = NewLimiterGroupWithConfig(totalRate, conf.ReadLimiter); err != nil {
+		return nil, err
+	}
+	if l.wlim, err = NewLimiterGroupWithConfig(totalRate, conf.WriteLimiter); err != nil {
+		return nil, err
+	}
+	if l.rmet, err = NewMeterGroupWithConfig(conf.ReadMeter); err != nil {
+		return nil, err
+	}
+	if l.wmet, err = NewMeterGroupWithConfig(conf.WriteMeter); err != nil {
+		return nil, err
+	}
+	// check the per-connection bit rate in advance
+	if _, err := newLimiterWithConfig(perConnRate, conf.ReadLimiter); err != nil {
+		return nil, err
+	}
+	if _, err := newLimiterWithConfig(perConnRate, conf.WriteLimiter); err != nil {
+		return nil, err
+	}
+	l.rmet.Start()
+	l.wmet.Start()
+	return l, nil
+}
+
+// Accept waits for and returns the next connection wrapped in a Conn.
+func (l *Listener) Accept() (net.Conn, error) {
+	nc, err := l.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	mr := l.rmet.NewReader(nc)
+	lr, err := l.rlim.NewLimiterReaderWithConfig(mr, l.rate, l.conf.ReadLimiter)
+	if err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	mw := l.wmet.NewWriter(nc)
+	lw, err := l.wlim.NewLimiterWriterWithConfig(mw, l.rate, l.conf.WriteLimiter)
+	if err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	c := newConn(nc, &Reader{mr: mr, lr: lr}, &Writer{mw: mw, lw: lw})
+	c.onClose = l.remove
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns[c] = struct{}{}
+
+	return c, nil
+}
+
+// remove removes the closed connection from the listener.
+func (l *Listener) remove(c *Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.conns, c)
+}
+
+// Close closes the underlying listener, and ends the aggregate bit rate
+// measurement. The accepted connections are not closed.
+func (l *Listener) Close() error {
+	_ = l.rmet.Close()
+	_ = l.wmet.Close()
+	return l.ln.Close()
+}
+
+// Addr returns the network address of the underlying listener.
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Conns returns the connections accepted and not yet closed. They can be used
+// to get the per-connection bit rates.
+func (l *Listener) Conns() []*Conn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	conns := make([]*Conn, 0, len(l.conns))
+	for c := range l.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// SetBitRate sets a new limiting bit rate of all the connections for each
+// direction.
+func (l *Listener) SetBitRate(rate infounit.BitRate) error {
+	if err := l.rlim.SetBitRate(rate); err != nil {
+		return err
+	}
+	return l.wlim.SetBitRate(rate)
+}
+
+// ReadBitRate returns the aggregate bit rate of reads of all the connections
+// in the most recent sampling period.
+func (l *Listener) ReadBitRate() infounit.BitRate {
+	return l.rmet.BitRate()
+}
+
+// WriteBitRate returns the aggregate bit rate of writes of all the
+// connections in the most recent sampling period.
+func (l *Listener) WriteBitRate() infounit.BitRate {
+	return l.wmet.BitRate()
+}
+
+// ReadTotal returns the aggregate data transfer amount, elapsed time, and bit
+// rate of reads of all the connections in the entire period since the
+// listener was created.
+func (l *Listener) ReadTotal() (infounit.ByteCount, time.Duration, infounit.BitRate) {
+	return l.rmet.Total()
+}
+
+// WriteTotal returns the aggregate data transfer amount, elapsed time, and bit
+// rate of writes of all the connections in the entire period since the
+// listener was created.
+func (l *Listener) WriteTotal() (infounit.ByteCount, time.Duration, infounit.BitRate) {
+	return l.wmet.Total()
+}
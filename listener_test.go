@@ -0,0 +1,207 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestListener_test1(t *testing.T) {
+	t.Parallel()
+
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	ln, err := speedio.NewListener(nl, 800000, 80000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the server sends 10000 bytes to each client
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = c.Write(make([]byte, 10000))
+			}()
+		}
+	}()
+
+	// 20000 bytes at the total 80 kbit/s: 10000 bytes burst and 10000
+	// bytes in the next 1s.
+	tc := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := net.Dial("tcp", nl.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer c.Close()
+			n, err := io.Copy(ioutil.Discard, c)
+			if err != nil {
+				t.Error(err)
+			}
+			if n != 10000 {
+				t.Errorf("unexpected len: want: 10000, got: %d", n)
+			}
+		}()
+	}
+	wg.Wait()
+	if et := time.Since(tc); et < time.Millisecond*900 {
+		t.Errorf("too fast: %s", et)
+	}
+	if bc, _, _ := ln.WriteTotal(); bc != 20000 {
+		t.Errorf("unexpected aggregate write total: %v", bc)
+	}
+	if bc, _, _ := ln.ReadTotal(); bc != 0 {
+		t.Errorf("unexpected aggregate read total: %v", bc)
+	}
+	for i := 0; i < 100 && 0 < len(ln.Conns()); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := len(ln.Conns()); n != 0 {
+		t.Errorf("unexpected number of conns: %d", n)
+	}
+}
+
+// pipeListener is a net.Listener accepting the ends of net.Pipe.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// dial returns the client end of a new connection, which is accepted
+// concurrently.
+func (l *pipeListener) dial() net.Conn {
+	c1, c2 := net.Pipe()
+	go func() {
+		select {
+		case l.conns <- c2:
+		case <-l.done:
+		}
+	}()
+	return c1
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, errors.New("closed")
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return &net.UnixAddr{Name: "pipe", Net: "pipe"} }
+
+// Each connection is held to the per-connection bit rate, and the connections
+// together to the total bit rate, with the limiters timed by a fake clock.
+func TestListener_perConn(t *testing.T) {
+	t.Parallel()
+
+	// writes 3000 bytes on each of n connections and returns the time taken.
+	run := func(n int) time.Duration {
+		t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+		clk := fakeclock.New(t0)
+		lconf := &speedio.LimiterConfig{
+			Resolution: time.Second,
+			MaxWait:    time.Millisecond * 500,
+			Clock:      clk,
+		}
+		pl := newPipeListener()
+
+		// 1000 bytes/s per connection, and 1500 bytes/s in total.
+		ln, err := speedio.NewListenerWithConfig(pl, 8000, 12000, &speedio.ConnConfig{
+			ReadLimiter:  lconf,
+			WriteLimiter: lconf,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+
+		done := make(chan error)
+		for i := 0; i < n; i++ {
+			cc := pl.dial()
+			defer cc.Close()
+			go func() { _, _ = io.Copy(ioutil.Discard, cc) }()
+			c, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			go func() {
+				_, err := c.Write(make([]byte, 3000))
+				done <- err
+			}()
+		}
+
+		// The clock is advanced only while all the writers are waiting.
+		for remaining := n; 0 < remaining; {
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Error(err)
+				}
+				remaining--
+				continue
+			default:
+			}
+			if clk.Pending() < remaining {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			next, _ := clk.Next()
+			clk.Set(next)
+		}
+		if bc, _, _ := ln.WriteTotal(); bc != infounit.ByteCount(3000*n) {
+			t.Errorf("unexpected aggregate write total: %v", bc)
+		}
+		return clk.Now().Sub(t0)
+	}
+
+	// 1000 bytes burst, and then 2000 bytes at the per-connection rate,
+	// within the total.
+	if et := run(1); et != time.Second*2 {
+		t.Errorf("single connection: unexpected elapsed time: want: 2s, got: %s", et)
+	}
+
+	// 1500 bytes burst, and then 4500 bytes at the total rate, exceeding
+	// the per-connection rates.
+	if et := run(2); et < time.Millisecond*2900 || time.Second*4 < et {
+		t.Errorf("two connections: unexpected elapsed time: %s", et)
+	}
+}
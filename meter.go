@@ -21,6 +21,17 @@ type meter struct {
 	started, closed     bool
 	startedAt, closedAt time.Time
 	totalBytes          infounit.ByteCount
+	parent              *meter // also records the transfer if non-nil
+	paused              bool
+	pausedAt            time.Time
+	pausedTotal         time.Duration // excluding the current pause
+	excludePaused       bool          // exclude the paused time from total
+	average             MeterAverage
+	retention           time.Duration
+	stats               *rateStats // of the folded periods
+	ewma                *ewma      // nil if no half-lives
+	closedCh            chan struct{}
+	updates             meterUpdates
 	mu                  sync.RWMutex
 }
 
@@ -50,6 +61,8 @@ func newMeter(resolution, sample time.Duration) (*meter, error) {
 		resolution: resolution,
 		sample:     sample,
 		cur:        &meterItem{},
+		stats:      newRateStats(),
+		closedCh:   make(chan struct{}),
 	}
 
 	tail := m.cur
@@ -63,15 +76,61 @@ func newMeter(resolution, sample time.Duration) (*meter, error) {
 	return m, nil
 }
 
+// newMeterWithConfig creates a meter with the specified configuration.
+func newMeterWithConfig(conf *MeterConfig) (*meter, error) {
+	m, err := newMeter(conf.Resolution, conf.Sample)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case conf.Average != MeterSMA && conf.Average != MeterEWMA:
+		return nil, fmt.Errorf("%w: unknown average %d", ErrInvalidParameter, conf.Average)
+	case conf.Average == MeterEWMA && len(conf.HalfLives) == 0:
+		return nil, fmt.Errorf("%w: no half-life for EWMA", ErrInvalidParameter)
+	}
+	if 0 < len(conf.HalfLives) {
+		if m.ewma, err = newEWMA(conf.HalfLives, conf.Resolution); err != nil {
+			return nil, err
+		}
+	}
+	m.retention = conf.Sample
+	switch {
+	case conf.Retention < 0:
+		return nil, fmt.Errorf("%w: negative retention %s", ErrInvalidParameter, conf.Retention)
+	case 0 < conf.Retention && conf.Retention < conf.Sample:
+		return nil, fmt.Errorf("%w: retention %s < sample %s", ErrInvalidParameter, conf.Retention, conf.Sample)
+	case conf.Sample < conf.Retention:
+		m.grow(int(conf.Retention/conf.Resolution) - int(conf.Sample/conf.Resolution))
+		m.retention = conf.Retention
+	}
+	m.excludePaused = conf.ExcludePaused
+	m.average = conf.Average
+	return m, nil
+}
+
+// grow adds n items to the ring to retain a longer history. It must be called
+// before the meter is started.
+func (m *meter) grow(n int) {
+	tail := m.cur.prev
+	for i := 0; i < n; i++ {
+		tail.next = &meterItem{prev: tail}
+		tail = tail.next
+	}
+	tail.next = m.cur
+	m.cur.prev = tail
+}
+
 // start starts measuring the data transfer.
 func (m *meter) start(tc time.Time) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	if m.started {
+		m.mu.Unlock()
 		return
 	}
 	m.started, m.startedAt = true, tc
 	m.cur.start, m.cur.end = 0, m.startedAt.Add(m.resolution)
+	m.mu.Unlock()
+	m.runUpdates()
 }
 
 // close stops measuring the data transfer.
@@ -82,18 +141,82 @@ func (m *meter) close(tc time.Time) {
 		return
 	}
 	m.closed, m.closedAt = true, tc
+	close(m.closedCh)
 }
 
-// record records the data transfer into the meter.
+// pause records the start of a pause. The paused time is recorded only after
+// the meter is started.
+func (m *meter) pause(tc time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.started || m.closed || m.paused {
+		return
+	}
+	m.paused, m.pausedAt = true, tc
+}
+
+// resume records the end of a pause.
+func (m *meter) resume(tc time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.paused || m.closed {
+		return
+	}
+	m.paused = false
+	m.pausedTotal += tc.Sub(m.pausedAt)
+}
+
+// pausedTime returns the total paused time until tc, or until the meter is
+// closed. It must be called with m.mu held.
+func (m *meter) pausedTime(tc time.Time) time.Duration {
+	if !m.paused {
+		return m.pausedTotal
+	}
+	if m.closed {
+		tc = m.closedAt
+	}
+	return m.pausedTotal + tc.Sub(m.pausedAt)
+}
+
+// pausedDuration returns the total paused time until tc.
+func (m *meter) pausedDuration(tc time.Time) time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pausedTime(tc)
+}
+
+// record records the data transfer into the meter. It is also recorded into
+// the parent meter and its ancestors, which are started if not yet. Nothing is
+// recorded into a closed meter, nor passed from it to the parent, so that the
+// total of a closed meter is kept as of the close.
 func (m *meter) record(tc time.Time, b infounit.ByteCount) {
+	m.mu.RLock()
+	closed := m.closed
+	m.mu.RUnlock()
+	if closed {
+		return
+	}
+	if m.parent != nil {
+		m.parent.start(tc)
+		m.parent.record(tc, b)
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if m.closed {
+		return
+	}
 	if tc.Before(m.cur.end) {
 		m.cur.vol += float64(b)
 		m.totalBytes += b
 		return
 	}
+	start := tc.Sub(m.startedAt) / m.resolution * m.resolution
+	if m.ewma != nil {
+		m.ewma.advance(m.ewma.rates, m.cur.vol, m.cur.start, start, m.resolution)
+	}
+	m.stats.add(m.cur.vol*bpscoef/float64(m.resolution), 1)
+	m.stats.add(0, int((start-m.cur.start)/m.resolution)-1)
 	m.last, m.cur = m.cur, m.cur.next
 	switch m.first {
 	case nil:
@@ -101,7 +224,7 @@ func (m *meter) record(tc time.Time, b infounit.ByteCount) {
 	case m.cur:
 		m.first = m.first.next
 	}
-	m.cur.start = tc.Sub(m.startedAt) / m.resolution * m.resolution
+	m.cur.start = start
 	m.cur.end = m.startedAt.Add(m.cur.start + m.resolution)
 	m.cur.vol = float64(b)
 	m.totalBytes += b
@@ -110,11 +233,17 @@ func (m *meter) record(tc time.Time, b infounit.ByteCount) {
 // bpscoef is a coefficient used for bit rate calculation.
 const bpscoef = 8 * float64(time.Second)
 
-// bitRate returns the bit rate in the last sample period.
+// bitRate returns the bit rate in the last sample period, or the EWMA of the
+// first half-life in the EWMA mode. It is 0 until the first resolution period
+// completes, and the completed periods are counted even if no transfer
+// follows them.
 func (m *meter) bitRate(tc time.Time) infounit.BitRate {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	if m.last == nil {
+	if m.average == MeterEWMA {
+		return infounit.BitRate(m.ewmaRates(tc)[0])
+	}
+	if !m.started || m.last == nil && tc.Before(m.cur.end) {
 		return infounit.BitRate(0)
 	}
 
@@ -134,7 +263,7 @@ func (m *meter) bitRate(tc time.Time) infounit.BitRate {
 	var sum float64
 	for i := newest; sampleStart <= i.start; i = i.prev {
 		sum += i.vol
-		if i == m.first {
+		if i == m.first || m.last == nil { // no item before the cur
 			break
 		}
 	}
@@ -142,23 +271,143 @@ func (m *meter) bitRate(tc time.Time) infounit.BitRate {
 	return infounit.BitRate(sum * bpscoef / float64(sampleWidth))
 }
 
+// ewmaBitRates returns the EWMA bit rates for the half-lives, or nil if no
+// half-lives are configured.
+func (m *meter) ewmaBitRates(tc time.Time) []infounit.BitRate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.ewma == nil {
+		return nil
+	}
+	rates := m.ewmaRates(tc)
+	brs := make([]infounit.BitRate, len(rates))
+	for i, r := range rates {
+		brs[i] = infounit.BitRate(r)
+	}
+	return brs
+}
+
+// ewmaRates returns the EWMA bit rates at tc, including the buckets completed
+// by tc but not yet folded. It must be called with m.mu held.
+func (m *meter) ewmaRates(tc time.Time) []float64 {
+	rates := append([]float64(nil), m.ewma.rates...)
+	if !m.started {
+		return rates
+	}
+	if m.closed {
+		tc = m.closedAt
+	}
+	if !tc.Before(m.cur.end) {
+		start := tc.Sub(m.startedAt) / m.resolution * m.resolution
+		m.ewma.advance(rates, m.cur.vol, m.cur.start, start, m.resolution)
+	}
+	return rates
+}
+
+// history returns the samples of each resolution period in the retention
+// period ending at tc, or at the close, in chronological order. The periods
+// without transfer are included as zero samples. The last sample may be
+// shorter than the resolution if the period is not complete.
+func (m *meter) history(tc time.Time) []MeterSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.started {
+		return nil
+	}
+	if m.closed {
+		tc = m.closedAt
+	}
+	end := tc.Sub(m.startedAt)
+	if end <= 0 {
+		return nil
+	}
+	begin := time.Duration(0)
+	if m.retention < end {
+		begin = (end - m.retention + m.resolution - 1) / m.resolution * m.resolution
+	}
+
+	vols := make(map[time.Duration]float64)
+	for i := m.cur; begin <= i.start; i = i.prev {
+		vols[i.start] = i.vol
+		if i == m.first || m.last == nil {
+			break
+		}
+	}
+
+	samples := make([]MeterSample, 0, (end-begin+m.resolution-1)/m.resolution)
+	for off := begin; off < end; off += m.resolution {
+		d := m.resolution
+		if end < off+d {
+			d = end - off
+		}
+		vol := vols[off]
+		samples = append(samples, MeterSample{
+			Start:    m.startedAt.Add(off),
+			Duration: d,
+			Bytes:    infounit.ByteCount(vol),
+			BitRate:  infounit.BitRate(vol * bpscoef / float64(d)),
+		})
+	}
+	return samples
+}
+
+// rateStats returns the statistics of the bit rates of the resolution periods
+// completed by tc. If the meter is closed, the last period ending at the close
+// is also included even if it is shorter than the resolution.
+func (m *meter) rateStats(tc time.Time) MeterStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.started {
+		return MeterStats{}
+	}
+	if m.closed {
+		tc = m.closedAt
+	}
+	end := tc.Sub(m.startedAt)
+	aligned := end / m.resolution * m.resolution
+	st := m.stats.clone()
+	if m.cur.start < aligned {
+		st.add(m.cur.vol*bpscoef/float64(m.resolution), 1)
+		st.add(0, int((aligned-m.cur.start)/m.resolution)-1)
+	}
+	if m.closed && aligned < end {
+		var vol float64
+		if m.cur.start == aligned {
+			vol = m.cur.vol
+		}
+		st.add(vol*bpscoef/float64(end-aligned), 1)
+	}
+	return st.stats()
+}
+
 // total returns the data transfer amount, elapsed time, and bit rate
-// in the entire period from start to close.
+// in the entire period from start to close. The transfer in the current
+// resolution period is included even before the first period completes, and
+// all of them are zero only before the start. If excludePaused is set, the
+// paused time is excluded from the elapsed time.
 func (m *meter) total(tc time.Time) (infounit.ByteCount, time.Duration, infounit.BitRate) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	if m.last == nil {
+	if !m.started {
 		return 0, 0, 0
 	}
 	if m.closed {
 		tc = m.closedAt
 	}
 	b, d := m.totalBytes, tc.Sub(m.startedAt)
+	if m.excludePaused {
+		d -= m.pausedTime(tc)
+	}
+	return b, d, totalBitRate(b, d)
+}
+
+// totalBitRate returns the bit rate of b bytes transferred in d.
+func totalBitRate(b infounit.ByteCount, d time.Duration) infounit.BitRate {
 	switch {
 	case b == 0:
-		return 0, d, 0
+		return 0
 	case d == 0:
-		return b, d, infounit.BitRate(math.Inf(+1))
+		return infounit.BitRate(math.Inf(+1))
 	}
-	return b, d, infounit.BitRate(float64(b) * bpscoef / float64(d))
+	return infounit.BitRate(float64(b) * bpscoef / float64(d))
 }
@@ -6,6 +6,8 @@ package speedio
 
 import (
 	"time"
+
+	"github.com/tunabay/go-infounit"
 )
 
 // MeterConfig indicates the configuration parameter of bit rate measurement.
@@ -19,11 +21,55 @@ import (
 // It must be an integral multiple of Resolution for accurate measurements.
 // Also, it must be at least twice the Resolution.
 // Longer sample periods increase memory usage for measurements.
+//
+// Clock is the source of the current time. If it is nil, SystemClock is used.
+//
+// ExcludePaused specifies whether the time paused by Pause of Reader or Writer
+// is excluded from the elapsed time returned by Total, and from the bit rate
+// calculated from it.
+//
+// HalfLives are the half-lives of the exponentially weighted moving averages
+// (EWMA) of the bit rate returned by EWMABitRates, such as 1s, 10s and 60s. The
+// averages are updated every Resolution, so the half-lives should be longer
+// than Resolution. Average is the kind of the moving average returned by
+// BitRate. With MeterEWMA, BitRate returns the EWMA of the first half-life,
+// which is smoother than the simple moving average over Sample.
+//
+// Retention is the length of the most recent period of which the samples are
+// retained for History. If it is 0, Sample is used. It must not be shorter than
+// Sample, and it should be an integral multiple of Resolution. Longer retention
+// periods increase memory usage for measurements.
 type MeterConfig struct {
-	Resolution time.Duration
-	Sample     time.Duration // must be an integral multiple of Resolution
+	Resolution    time.Duration
+	Sample        time.Duration // must be an integral multiple of Resolution
+	Clock         Clock
+	ExcludePaused bool
+	HalfLives     []time.Duration
+	Average       MeterAverage
+	Retention     time.Duration
 }
 
+// MeterSample is a sample of the bit rate measurement in a resolution period,
+// returned by History.
+type MeterSample struct {
+	Start    time.Time
+	Duration time.Duration
+	Bytes    infounit.ByteCount
+	BitRate  infounit.BitRate
+}
+
+// MeterAverage is the kind of the moving average of the bit rate.
+type MeterAverage int
+
+const (
+	// MeterSMA is the simple moving average over the sample duration.
+	MeterSMA MeterAverage = iota
+
+	// MeterEWMA is the exponentially weighted moving average with the first
+	// half-life.
+	MeterEWMA
+)
+
 // MinResolution is the minimum time resolution to measure bit rate.
 const MinMeterResolution time.Duration = time.Millisecond * 100
 
@@ -0,0 +1,44 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ewma holds the exponentially weighted moving averages of the bit rate for
+// multiple half-lives. The averages are updated once per resolution, and each
+// resolution period is weighted as a whole.
+type ewma struct {
+	decay []float64 // weight of the previous average per resolution
+	rates []float64 // bits per second
+}
+
+// newEWMA creates an ewma for the half-lives updated every resolution.
+func newEWMA(halfLives []time.Duration, resolution time.Duration) (*ewma, error) {
+	e := &ewma{
+		decay: make([]float64, len(halfLives)),
+		rates: make([]float64, len(halfLives)),
+	}
+	for i, h := range halfLives {
+		if h <= 0 {
+			return nil, fmt.Errorf("%w: half-life %s <= 0", ErrInvalidParameter, h)
+		}
+		e.decay[i] = math.Exp2(-float64(resolution) / float64(h))
+	}
+	return e, nil
+}
+
+// advance folds the period of a resolution starting at start with vol bytes,
+// followed by the periods without transfer until next, into rates.
+func (e *ewma) advance(rates []float64, vol float64, start, next, resolution time.Duration) {
+	r := vol * bpscoef / float64(resolution)
+	idle := float64((next-start)/resolution - 1)
+	for i, d := range e.decay {
+		rates[i] = (r + d*(rates[i]-r)) * math.Pow(d, idle)
+	}
+}
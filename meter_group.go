@@ -0,0 +1,164 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"io"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// MeterGroup implements bit rate measurement of the total of multiple
+// MeterReader and MeterWriter objects. The data transferred by all the members
+// created by the group is also recorded into the group.
+//
+// Groups can be nested. The data recorded into a child group is also recorded
+// into the group.
+type MeterGroup struct {
+	conf MeterConfig
+	met  *meter
+}
+
+// NewMeterGroup creates a new MeterGroup with default configuration.
+func NewMeterGroup() *MeterGroup {
+	g, err := NewMeterGroupWithConfig(nil)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// NewMeterGroupWithConfig creates a new MeterGroup with the specified
+// configuration. If conf is nil, the default configuration will be used. The
+// same configuration is used for the members created by the group.
+func NewMeterGroupWithConfig(conf *MeterConfig) (*MeterGroup, error) {
+	if conf == nil {
+		conf = DefaultMeterConfig
+	}
+	g := &MeterGroup{conf: *conf}
+	if g.conf.Clock == nil {
+		g.conf.Clock = SystemClock
+	}
+	met, err := newMeterWithConfig(&g.conf)
+	if err != nil {
+		return nil, err
+	}
+	g.met = met
+	return g, nil
+}
+
+// NewGroup creates a new MeterGroup as a child of the group, with the same
+// configuration as the group.
+func (g *MeterGroup) NewGroup() *MeterGroup {
+	c, err := NewMeterGroupWithConfig(&g.conf)
+	if err != nil {
+		panic(err)
+	}
+	c.met.parent = g.met
+	return c
+}
+
+// NewReader creates a new MeterReader as a member of the group.
+func (g *MeterGroup) NewReader(rd io.Reader) *MeterReader {
+	r, err := NewMeterReaderWithConfig(rd, &g.conf)
+	if err != nil {
+		panic(err)
+	}
+	r.met.parent = g.met
+	return r
+}
+
+// NewWriter creates a new MeterWriter as a member of the group.
+func (g *MeterGroup) NewWriter(wr io.Writer) *MeterWriter {
+	w, err := NewMeterWriterWithConfig(wr, &g.conf)
+	if err != nil {
+		panic(err)
+	}
+	w.met.parent = g.met
+	return w
+}
+
+// Start starts the measurement. Calling this Start is optional, and normally it
+// is started automatically at the first transfer by any of the members.
+func (g *MeterGroup) Start() {
+	g.StartAt(g.conf.Clock.Now())
+}
+
+// StartAt starts the measurement at specified time.
+func (g *MeterGroup) StartAt(tc time.Time) {
+	g.met.start(tc)
+}
+
+// Close ends the bit rate calculation period of the group. It does not close
+// the members, but their transfers after the close are not counted by the
+// group.
+func (g *MeterGroup) Close() error {
+	return g.CloseAt(g.conf.Clock.Now())
+}
+
+// CloseAt is the same as Close, except that it uses time specified as the end
+// time.
+func (g *MeterGroup) CloseAt(tc time.Time) error {
+	g.met.start(tc)
+	g.met.close(tc)
+	return nil
+}
+
+// BitRate calculates and returns the total bit rate of the members in the most
+// recent sampling period.
+func (g *MeterGroup) BitRate() infounit.BitRate {
+	return g.met.bitRate(g.conf.Clock.Now())
+}
+
+// EWMABitRates returns the exponentially weighted moving averages of the bit
+// rate for the half-lives in MeterConfig, in the same order. It returns nil if
+// no half-lives are configured.
+func (g *MeterGroup) EWMABitRates() []infounit.BitRate {
+	return g.met.ewmaBitRates(g.conf.Clock.Now())
+}
+
+// History returns the samples of each resolution period retained by the
+// meter, in chronological order, ending now or at the close. The periods
+// without transfer are included as zero samples, and the last sample may be
+// shorter than the resolution. The length of the history is the Retention of
+// MeterConfig.
+func (g *MeterGroup) History() []MeterSample {
+	return g.met.history(g.conf.Clock.Now())
+}
+
+// Stats returns the statistics of the bit rates of the resolution periods in
+// the entire period from start, such as the peak, the minimum and the
+// percentiles. When it is called after being closed, it always returns the
+// same statistics from start to close.
+func (g *MeterGroup) Stats() MeterStats {
+	return g.met.rateStats(g.conf.Clock.Now())
+}
+
+// OnUpdate registers f to be called each time a resolution period completes,
+// with the snapshot of the period, and returns the function to cancel it. f is
+// called even if nothing is transferred in the period, and once more for the
+// last period at Close, after which the updates stop. f is called from a
+// separate goroutine and should not block. The goroutine runs only while the
+// measurement is started and not closed, and while at least one registration
+// remains.
+func (g *MeterGroup) OnUpdate(f func(MeterSnapshot)) func() {
+	return g.met.onUpdate(g.conf.Clock, f)
+}
+
+// Subscribe registers ch to receive the snapshot each time a resolution period
+// completes, in the same way as OnUpdate, and returns the function to cancel
+// it. The snapshots are sent without blocking, and dropped if ch is not ready.
+// ch is not closed at Close.
+func (g *MeterGroup) Subscribe(ch chan<- MeterSnapshot) func() {
+	return g.met.subscribe(g.conf.Clock, ch)
+}
+
+// Total returns the total data transfer amount of the members, elapsed time,
+// and bit rate in the entire period from start. When it is called after being
+// closed, it always returns the same statistics from start to close.
+func (g *MeterGroup) Total() (infounit.ByteCount, time.Duration, infounit.BitRate) {
+	return g.met.total(g.conf.Clock.Now())
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestMeterGroup_test1(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	g, err := speedio.NewMeterGroupWithConfig(&speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 2,
+		Clock:      clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := g.NewGroup()
+	w1 := g.NewWriter(ioutil.Discard)
+	w2 := sub.NewWriter(ioutil.Discard)
+	g.Start()
+	for i := 0; i < 4; i++ {
+		clk.Set(t0.Add(time.Second*time.Duration(i) + time.Millisecond*500))
+		_, _ = w1.Write(make([]byte, 1000))
+		_, _ = w2.Write(make([]byte, 3000))
+	}
+	clk.Set(t0.Add(time.Second * 4))
+	if br := g.BitRate(); br != 32000 {
+		t.Errorf("unexpected group bit rate: want: 32000, got: %v", br)
+	}
+	if br := sub.BitRate(); br != 24000 {
+		t.Errorf("unexpected child group bit rate: want: 24000, got: %v", br)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bc, et, br := g.Total(); bc != 16000 || et != time.Second*4 || br != 32000 {
+		t.Errorf("unexpected total: %v, %v, %v", bc, et, br)
+	}
+	if bc, _, _ := w1.Total(); bc != 4000 {
+		t.Errorf("unexpected member total: %v", bc)
+	}
+}
+
+// The members transferring after the group is closed do not change the total
+// of the group.
+func TestMeterGroup_closed(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	g, err := speedio.NewMeterGroupWithConfig(&speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 2,
+		Clock:      clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := g.NewGroup()
+	w1 := g.NewWriter(ioutil.Discard)
+	w2 := sub.NewWriter(ioutil.Discard)
+	g.Start()
+	clk.Set(t0.Add(time.Millisecond * 500))
+	_, _ = w1.Write(make([]byte, 500))
+	_, _ = w2.Write(make([]byte, 500))
+	clk.Set(t0.Add(time.Second))
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		clk.Advance(time.Millisecond * 500)
+		_, _ = w1.Write(make([]byte, 1000))
+		_, _ = w2.Write(make([]byte, 1000))
+	}
+	if bc, et, br := g.Total(); bc != 1000 || et != time.Second || br != 8000 {
+		t.Errorf("unexpected total after close: %v, %v, %v", bc, et, br)
+	}
+	if bc, _, _ := sub.Total(); bc != 5500 {
+		t.Errorf("unexpected child group total: %v", bc)
+	}
+	if bc, _, _ := w1.Total(); bc != 5500 {
+		t.Errorf("unexpected member total: %v", bc)
+	}
+}
@@ -17,6 +17,7 @@ type MeterReader struct {
 	rd              io.Reader // underlying reader provided by the client
 	resolution      time.Duration
 	sample          time.Duration
+	clock           Clock
 	met             *meter
 	started, closed bool
 	mu              sync.Mutex
@@ -43,8 +44,12 @@ func NewMeterReaderWithConfig(rd io.Reader, conf *MeterConfig) (*MeterReader, er
 		rd:         rd,
 		resolution: conf.Resolution,
 		sample:     conf.Sample,
+		clock:      conf.Clock,
 	}
-	met, err := newMeter(r.resolution, r.sample)
+	if r.clock == nil {
+		r.clock = SystemClock
+	}
+	met, err := newMeterWithConfig(conf)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +61,7 @@ func NewMeterReaderWithConfig(rd io.Reader, conf *MeterConfig) (*MeterReader, er
 // is started automatically at the first read. This is used to adjust the
 // transfer start time for bit rate calculation.
 func (r *MeterReader) Start() {
-	r.StartAt(time.Now())
+	r.StartAt(r.clock.Now())
 }
 
 // StartAt starts the measurement at specified time. This is used to adjust the
@@ -75,7 +80,7 @@ func (r *MeterReader) StartAt(tc time.Time) {
 // period. If the underlying reader implements io.ReadCloser, its Close method
 // is also called.
 func (r *MeterReader) Close() error {
-	return r.close(time.Now(), true)
+	return r.close(r.clock.Now(), true)
 }
 
 // CloseAt is the same as Close, except that it uses time specified as the end
@@ -86,7 +91,7 @@ func (r *MeterReader) CloseAt(tc time.Time) error {
 
 // CloseSingle is the same as Close except that it does not close the underlying reader.
 func (r *MeterReader) CloseSingle() error {
-	return r.close(time.Now(), false)
+	return r.close(r.clock.Now(), false)
 }
 
 // CloseSingleAt is the same as CloseAt except that it does not close the underlying reader.
@@ -125,7 +130,7 @@ func (r *MeterReader) Read(p []byte) (int, error) {
 	r.Start()
 	n, err := r.rd.Read(p)
 	if 0 < n {
-		r.met.record(time.Now(), infounit.ByteCount(n))
+		r.met.record(r.clock.Now(), infounit.ByteCount(n))
 	}
 	return n, err
 }
@@ -133,12 +138,55 @@ func (r *MeterReader) Read(p []byte) (int, error) {
 // BitRate calculates and returns the bit rate in the most recent sampling
 // period.
 func (r *MeterReader) BitRate() infounit.BitRate {
-	return r.met.bitRate(time.Now())
+	return r.met.bitRate(r.clock.Now())
+}
+
+// EWMABitRates returns the exponentially weighted moving averages of the bit
+// rate for the half-lives in MeterConfig, in the same order. It returns nil if
+// no half-lives are configured.
+func (r *MeterReader) EWMABitRates() []infounit.BitRate {
+	return r.met.ewmaBitRates(r.clock.Now())
+}
+
+// History returns the samples of each resolution period retained by the
+// meter, in chronological order, ending now or at the close. The periods
+// without transfer are included as zero samples, and the last sample may be
+// shorter than the resolution. The length of the history is the Retention of
+// MeterConfig.
+func (r *MeterReader) History() []MeterSample {
+	return r.met.history(r.clock.Now())
+}
+
+// Stats returns the statistics of the bit rates of the resolution periods in
+// the entire period from start, such as the peak, the minimum and the
+// percentiles. When it is called after being closed, it always returns the
+// same statistics from start to close.
+func (r *MeterReader) Stats() MeterStats {
+	return r.met.rateStats(r.clock.Now())
+}
+
+// OnUpdate registers f to be called each time a resolution period completes,
+// with the snapshot of the period, and returns the function to cancel it. f is
+// called even if nothing is transferred in the period, and once more for the
+// last period at Close, after which the updates stop. f is called from a
+// separate goroutine and should not block. The goroutine runs only while the
+// measurement is started and not closed, and while at least one registration
+// remains.
+func (r *MeterReader) OnUpdate(f func(MeterSnapshot)) func() {
+	return r.met.onUpdate(r.clock, f)
+}
+
+// Subscribe registers ch to receive the snapshot each time a resolution period
+// completes, in the same way as OnUpdate, and returns the function to cancel
+// it. The snapshots are sent without blocking, and dropped if ch is not ready.
+// ch is not closed at Close.
+func (r *MeterReader) Subscribe(ch chan<- MeterSnapshot) func() {
+	return r.met.subscribe(r.clock, ch)
 }
 
 // Total returns the data transfer amount, elapsed time, and bit rate in the
 // entire period from start. When it is called after being closed, it always
 // returns the same statistics from start to close.
 func (r *MeterReader) Total() (infounit.ByteCount, time.Duration, infounit.BitRate) {
-	return r.met.total(time.Now())
+	return r.met.total(r.clock.Now())
 }
@@ -0,0 +1,123 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"math"
+	"sort"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// MeterStats is the statistics of the bit rates of the resolution periods in
+// the entire period from start, returned by Stats.
+//
+// Samples is the number of the periods, including the periods without
+// transfer. The last period is counted only after it completes, or after the
+// meter is closed. Peak and Min are the highest and lowest bit rates, and Mean
+// and StdDev are the mean and standard deviation of them. P50, P95 and P99 are
+// the percentiles, which are approximated within 1% relative error.
+type MeterStats struct {
+	Samples       int
+	Peak, Min     infounit.BitRate
+	Mean, StdDev  infounit.BitRate
+	P50, P95, P99 infounit.BitRate
+}
+
+// statsBase is the ratio of the boundaries of the adjacent bins of the
+// histogram of rateStats.
+const statsBase = 1.02
+
+// rateStats accumulates the bit rates in a streaming manner, with a histogram
+// of logarithmic bins so that the memory is bounded by the dynamic range of
+// the bit rates, not by the number of the samples.
+type rateStats struct {
+	n        int
+	min, max float64
+	mean, m2 float64 // Welford's algorithm
+	zeros    int
+	bins     map[int]int
+}
+
+// newRateStats creates an empty rateStats.
+func newRateStats() *rateStats {
+	return &rateStats{bins: make(map[int]int)}
+}
+
+// add adds n samples of the bit rate r.
+func (s *rateStats) add(r float64, n int) {
+	if n <= 0 {
+		return
+	}
+	if s.n == 0 || r < s.min {
+		s.min = r
+	}
+	if s.n == 0 || s.max < r {
+		s.max = r
+	}
+	delta, total := r-s.mean, float64(s.n+n)
+	s.mean += delta * float64(n) / total
+	s.m2 += delta * delta * float64(s.n) * float64(n) / total
+	s.n += n
+	if r <= 0 {
+		s.zeros += n
+		return
+	}
+	s.bins[int(math.Floor(math.Log(r)/math.Log(statsBase)))] += n
+}
+
+// clone returns a copy of the rateStats.
+func (s *rateStats) clone() *rateStats {
+	c := *s
+	c.bins = make(map[int]int, len(s.bins))
+	for k, v := range s.bins {
+		c.bins[k] = v
+	}
+	return &c
+}
+
+// stats returns the statistics of the samples.
+func (s *rateStats) stats() MeterStats {
+	if s.n == 0 {
+		return MeterStats{}
+	}
+	st := MeterStats{
+		Samples: s.n,
+		Peak:    infounit.BitRate(s.max),
+		Min:     infounit.BitRate(s.min),
+		Mean:    infounit.BitRate(s.mean),
+		StdDev:  infounit.BitRate(math.Sqrt(s.m2 / float64(s.n))),
+	}
+	keys := make([]int, 0, len(s.bins))
+	for k := range s.bins {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	st.P50 = s.percentile(keys, 0.50)
+	st.P95 = s.percentile(keys, 0.95)
+	st.P99 = s.percentile(keys, 0.99)
+	return st
+}
+
+// percentile returns the p-quantile of the samples, using the geometric middle
+// of the bin, clamped into the range of the samples. keys are the sorted keys
+// of the bins.
+func (s *rateStats) percentile(keys []int, p float64) infounit.BitRate {
+	rank := int(math.Ceil(p * float64(s.n)))
+	if rank < 1 {
+		rank = 1
+	}
+	cnt := s.zeros
+	if rank <= cnt {
+		return 0
+	}
+	for _, k := range keys {
+		if cnt += s.bins[k]; rank <= cnt {
+			r := math.Pow(statsBase, float64(k)+0.5)
+			return infounit.BitRate(math.Max(s.min, math.Min(s.max, r)))
+		}
+	}
+	return infounit.BitRate(s.max)
+}
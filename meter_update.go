@@ -0,0 +1,199 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// MeterSnapshot is an update delivered to the functions registered by
+// OnUpdate and to the channels registered by Subscribe, each time a resolution
+// period of the meter completes.
+//
+// Start and Duration are the period, and Bytes is the data transfer amount in
+// the period, which is 0 if the stream is idle. BitRate is the bit rate in the
+// most recent sampling period at the end of the period, as returned by
+// BitRate. Total, Elapsed and AverageBitRate are the statistics in the entire
+// period from start, as returned by Total.
+type MeterSnapshot struct {
+	Start          time.Time
+	Duration       time.Duration
+	Bytes          infounit.ByteCount
+	BitRate        infounit.BitRate
+	Total          infounit.ByteCount
+	Elapsed        time.Duration
+	AverageBitRate infounit.BitRate
+}
+
+// meterUpdates holds the functions to be called at each update of the meter.
+type meterUpdates struct {
+	fns     map[int]func(MeterSnapshot)
+	nextID  int
+	clock   Clock
+	running bool
+	stopc   chan struct{} // closed when the last function is cancelled
+	mu      sync.Mutex
+}
+
+// onUpdate registers f to be called at each update of the meter, and returns
+// the function to cancel it. The goroutine delivering the updates runs while
+// the meter is started and not closed, and while at least one registration
+// remains.
+func (m *meter) onUpdate(clock Clock, f func(MeterSnapshot)) func() {
+	m.updates.mu.Lock()
+	if m.updates.fns == nil {
+		m.updates.fns = make(map[int]func(MeterSnapshot))
+	}
+	id := m.updates.nextID
+	m.updates.nextID++
+	m.updates.fns[id] = f
+	m.updates.clock = clock
+	m.updates.mu.Unlock()
+	m.runUpdates()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.updates.mu.Lock()
+			defer m.updates.mu.Unlock()
+			delete(m.updates.fns, id)
+			if len(m.updates.fns) == 0 && m.updates.running {
+				m.updates.running = false
+				close(m.updates.stopc)
+			}
+		})
+	}
+}
+
+// subscribe registers ch to receive the updates of the meter, and returns the
+// function to cancel it. The updates are sent without blocking, and dropped if
+// ch is not ready.
+func (m *meter) subscribe(clock Clock, ch chan<- MeterSnapshot) func() {
+	return m.onUpdate(clock, func(s MeterSnapshot) {
+		select {
+		case ch <- s:
+		default:
+		}
+	})
+}
+
+// runUpdates starts the goroutine delivering the updates, if the meter is
+// started and not closed, any function is registered, and it is not running
+// yet. It is called when the meter is started and when a function is
+// registered.
+func (m *meter) runUpdates() {
+	m.mu.RLock()
+	started, closed, startedAt := m.started, m.closed, m.startedAt
+	m.mu.RUnlock()
+	if !started || closed {
+		return
+	}
+	m.updates.mu.Lock()
+	defer m.updates.mu.Unlock()
+	if m.updates.running || len(m.updates.fns) == 0 {
+		return
+	}
+	m.updates.running = true
+	m.updates.stopc = make(chan struct{})
+	go m.run(m.updates.clock, startedAt, m.updates.stopc)
+}
+
+// run delivers the updates each time a resolution period completes, until
+// stopc is closed or the meter is closed. At the close, the last period is
+// delivered even if it is shorter than the resolution.
+func (m *meter) run(clock Clock, startedAt time.Time, stopc <-chan struct{}) {
+	off := clock.Now().Sub(startedAt) / m.resolution * m.resolution
+	if off < 0 {
+		off = 0
+	}
+	for {
+		m.mu.RLock()
+		closed, closedAt := m.closed, m.closedAt
+		m.mu.RUnlock()
+		end := clock.Now().Sub(startedAt)
+		if closed {
+			end = closedAt.Sub(startedAt)
+		}
+		for ; off+m.resolution <= end; off += m.resolution {
+			m.fire(stopc, off, m.resolution)
+		}
+		if closed {
+			if off < end {
+				m.fire(stopc, off, end-off)
+			}
+			return
+		}
+		timer := clock.NewTimer(startedAt.Add(off + m.resolution).Sub(clock.Now()))
+		select {
+		case <-timer.C():
+		case <-m.closedCh:
+			timer.Stop()
+		case <-stopc:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// fire calls the registered functions with the snapshot of the period of d
+// from off, unless stopc is closed.
+func (m *meter) fire(stopc <-chan struct{}, off, d time.Duration) {
+	s := m.snapshot(off, d)
+
+	m.updates.mu.Lock()
+	if isClosedChan(stopc) {
+		m.updates.mu.Unlock()
+		return
+	}
+	fns := make([]func(MeterSnapshot), 0, len(m.updates.fns))
+	for id := 0; id < m.updates.nextID; id++ {
+		if f, ok := m.updates.fns[id]; ok {
+			fns = append(fns, f) // in the order of the registration
+		}
+	}
+	m.updates.mu.Unlock()
+	for _, f := range fns {
+		f(s)
+	}
+}
+
+// snapshot returns the snapshot of the period of d from off. The total is
+// the one at the end of the period, excluding the transfer recorded after it.
+func (m *meter) snapshot(off, d time.Duration) MeterSnapshot {
+	m.mu.RLock()
+	startedAt := m.startedAt
+	m.mu.RUnlock()
+	tc := startedAt.Add(off + d)
+	s := MeterSnapshot{
+		Start:    startedAt.Add(off),
+		Duration: d,
+		BitRate:  m.bitRate(tc),
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	total := float64(m.totalBytes)
+	for i := m.cur; off <= i.start; i = i.prev {
+		switch {
+		case off+d <= i.start: // after the period
+			total -= i.vol
+		case i.start == off:
+			s.Bytes = infounit.ByteCount(i.vol)
+		}
+		if i == m.first || m.last == nil {
+			break
+		}
+	}
+	s.Elapsed = off + d
+	if m.excludePaused {
+		s.Elapsed -= m.pausedTime(tc)
+	}
+	s.Total = infounit.ByteCount(total)
+	s.AverageBitRate = totalBitRate(s.Total, s.Elapsed)
+	return s
+}
@@ -17,6 +17,7 @@ type MeterWriter struct {
 	wr              io.Writer // underlying writer provided by the client
 	resolution      time.Duration
 	sample          time.Duration
+	clock           Clock
 	met             *meter
 	started, closed bool
 	mu              sync.Mutex
@@ -43,8 +44,12 @@ func NewMeterWriterWithConfig(wr io.Writer, conf *MeterConfig) (*MeterWriter, er
 		wr:         wr,
 		resolution: conf.Resolution,
 		sample:     conf.Sample,
+		clock:      conf.Clock,
 	}
-	met, err := newMeter(w.resolution, w.sample)
+	if w.clock == nil {
+		w.clock = SystemClock
+	}
+	met, err := newMeterWithConfig(conf)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +61,7 @@ func NewMeterWriterWithConfig(wr io.Writer, conf *MeterConfig) (*MeterWriter, er
 // is started automatically at the first write. This is used to adjust the
 // transfer start time for bit rate calculation.
 func (w *MeterWriter) Start() {
-	w.StartAt(time.Now())
+	w.StartAt(w.clock.Now())
 }
 
 // StartAt starts the measurement at specified time. This is used to adjust the
@@ -75,7 +80,7 @@ func (w *MeterWriter) StartAt(tc time.Time) {
 // period. If the underlying writer implements io.WriteCloser, its Close method
 // is also called.
 func (w *MeterWriter) Close() error {
-	return w.close(time.Now(), true)
+	return w.close(w.clock.Now(), true)
 }
 
 // CloseAt is the same as Close, except that it uses time specified as the end
@@ -86,7 +91,7 @@ func (w *MeterWriter) CloseAt(tc time.Time) error {
 
 // CloseSingle is the same as Close except that it does not close the underlying writer.
 func (w *MeterWriter) CloseSingle() error {
-	return w.close(time.Now(), false)
+	return w.close(w.clock.Now(), false)
 }
 
 // CloseSingleAt is the same as CloseAt except that it does not close the underlying writer.
@@ -125,7 +130,7 @@ func (w *MeterWriter) Write(p []byte) (int, error) {
 	w.Start()
 	n, err := w.wr.Write(p)
 	if 0 < n {
-		w.met.record(time.Now(), infounit.ByteCount(n))
+		w.met.record(w.clock.Now(), infounit.ByteCount(n))
 	}
 	return n, err
 }
@@ -133,12 +138,55 @@ func (w *MeterWriter) Write(p []byte) (int, error) {
 // BitRate calculates and returns the bit rate in the most recent sampling
 // period.
 func (w *MeterWriter) BitRate() infounit.BitRate {
-	return w.met.bitRate(time.Now())
+	return w.met.bitRate(w.clock.Now())
+}
+
+// EWMABitRates returns the exponentially weighted moving averages of the bit
+// rate for the half-lives in MeterConfig, in the same order. It returns nil if
+// no half-lives are configured.
+func (w *MeterWriter) EWMABitRates() []infounit.BitRate {
+	return w.met.ewmaBitRates(w.clock.Now())
+}
+
+// History returns the samples of each resolution period retained by the
+// meter, in chronological order, ending now or at the close. The periods
+// without transfer are included as zero samples, and the last sample may be
+// shorter than the resolution. The length of the history is the Retention of
+// MeterConfig.
+func (w *MeterWriter) History() []MeterSample {
+	return w.met.history(w.clock.Now())
+}
+
+// Stats returns the statistics of the bit rates of the resolution periods in
+// the entire period from start, such as the peak, the minimum and the
+// percentiles. When it is called after being closed, it always returns the
+// same statistics from start to close.
+func (w *MeterWriter) Stats() MeterStats {
+	return w.met.rateStats(w.clock.Now())
+}
+
+// OnUpdate registers f to be called each time a resolution period completes,
+// with the snapshot of the period, and returns the function to cancel it. f is
+// called even if nothing is transferred in the period, and once more for the
+// last period at Close, after which the updates stop. f is called from a
+// separate goroutine and should not block. The goroutine runs only while the
+// measurement is started and not closed, and while at least one registration
+// remains.
+func (w *MeterWriter) OnUpdate(f func(MeterSnapshot)) func() {
+	return w.met.onUpdate(w.clock, f)
+}
+
+// Subscribe registers ch to receive the snapshot each time a resolution period
+// completes, in the same way as OnUpdate, and returns the function to cancel
+// it. The snapshots are sent without blocking, and dropped if ch is not ready.
+// ch is not closed at Close.
+func (w *MeterWriter) Subscribe(ch chan<- MeterSnapshot) func() {
+	return w.met.subscribe(w.clock, ch)
 }
 
 // Total returns the data transfer amount, elapsed time, and bit rate in the
 // entire period from start. When it is called after being closed, it always
 // returns the same statistics from start to close.
 func (w *MeterWriter) Total() (infounit.ByteCount, time.Duration, infounit.BitRate) {
-	return w.met.total(time.Now())
+	return w.met.total(w.clock.Now())
 }
@@ -5,11 +5,15 @@
 package speedio_test
 
 import (
+	"errors"
 	"io/ioutil"
+	"math"
 	"testing"
 	"time"
 
+	"github.com/tunabay/go-infounit"
 	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
 )
 
 //
@@ -63,3 +67,432 @@ func TestMeterWriter_test1(t *testing.T) {
 	bc, et, br := w.Total()
 	t.Logf("total(n=%d): %v, %v, %v", sum, bc, et, br)
 }
+
+//
+func TestMeterWriter_fakeClock(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	conf := &speedio.MeterConfig{
+		Resolution: time.Millisecond * 500,
+		Sample:     time.Second * 3,
+		Clock:      clk,
+	}
+	w, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Start()
+	buf := make([]byte, 1000)
+	for i := 0; i < 8; i++ {
+		clk.Set(t0.Add(time.Millisecond * time.Duration(500*i+100)))
+		if _, err := w.Write(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clk.Set(t0.Add(time.Second * 4))
+	if br := w.BitRate(); br != 16000 {
+		t.Errorf("unexpected bit rate: want: 16000, got: %v", br)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bc, et, br := w.Total()
+	if bc != 8000 || et != time.Second*4 || br != 16000 {
+		t.Errorf("unexpected total: %v, %v, %v", bc, et, br)
+	}
+}
+
+// Total counts the transfer and the elapsed time from the start, even before
+// the first resolution period completes.
+func TestMeterWriter_totalFirstPeriod(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	conf := &speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 3,
+		Clock:      clk,
+	}
+	w, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc, et, br := w.Total(); bc != 0 || et != 0 || br != 0 {
+		t.Errorf("unexpected total before start: %v, %v, %v", bc, et, br)
+	}
+	w.Start()
+	clk.Advance(time.Millisecond * 250)
+	if bc, et, br := w.Total(); bc != 0 || et != time.Millisecond*250 || br != 0 {
+		t.Errorf("unexpected total without transfer: %v, %v, %v", bc, et, br)
+	}
+	if _, err := w.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Millisecond * 250)
+	if bc, et, br := w.Total(); bc != 1000 || et != time.Millisecond*500 || br != 16000 {
+		t.Errorf("unexpected total in the first period: %v, %v, %v", bc, et, br)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second * 5)
+	if bc, et, br := w.Total(); bc != 1000 || et != time.Millisecond*500 || br != 16000 {
+		t.Errorf("unexpected total after close: %v, %v, %v", bc, et, br)
+	}
+}
+
+// BitRate counts the first period after it completes, even if no transfer
+// follows.
+func TestMeterWriter_bitRateIdle(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	conf := &speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 2,
+		Clock:      clk,
+	}
+	w, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br := w.BitRate(); br != 0 {
+		t.Errorf("unexpected bit rate before start: %v", br)
+	}
+	if _, err := w.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if br := w.BitRate(); br != 0 {
+		t.Errorf("unexpected bit rate in the first period: %v", br)
+	}
+	for _, want := range []infounit.BitRate{8000, 4000, 0} {
+		clk.Advance(time.Second)
+		if br := w.BitRate(); br != want {
+			t.Errorf("%s: unexpected bit rate: want: %v, got: %v", clk.Now().Sub(t0), want, br)
+		}
+	}
+}
+
+//
+func TestMeterWriter_ewma(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := &speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 2,
+		Clock:      clk,
+		HalfLives:  []time.Duration{time.Second, time.Second * 10},
+		Average:    speedio.MeterEWMA,
+	}
+	w, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8000 bit/s for 2s, and then idle for 1s.
+	long := 1 - math.Exp2(-0.1)
+	steps := []struct {
+		write      bool
+		short, lng float64
+	}{
+		{true, 4000, 8000 * long},
+		{true, 6000, 8000 * long * (2 - long)},
+		{false, 3000, 8000 * long * (2 - long) * (1 - long)},
+	}
+	for i, step := range steps {
+		if step.write {
+			if _, err := w.Write(make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		clk.Advance(time.Second)
+		rates := w.EWMABitRates()
+		if len(rates) != 2 {
+			t.Fatalf("unexpected len: want: 2, got: %d", len(rates))
+		}
+		if rates[0] != infounit.BitRate(step.short) {
+			t.Errorf("#%d: unexpected short EWMA: want: %v, got: %v", i, step.short, rates[0])
+		}
+		if math.Abs(float64(rates[1])-step.lng) > 1e-6 {
+			t.Errorf("#%d: unexpected long EWMA: want: %v, got: %v", i, step.lng, rates[1])
+		}
+		if br := w.BitRate(); br != rates[0] {
+			t.Errorf("#%d: BitRate is not the first EWMA: %v", i, br)
+		}
+	}
+
+	conf.HalfLives = nil
+	if _, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, conf); !errors.Is(err, speedio.ErrInvalidParameter) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+//
+func TestMeterWriter_history(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	conf := &speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 2,
+		Clock:      clk,
+		Retention:  time.Second * 5,
+	}
+	w, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := w.History(); h != nil {
+		t.Errorf("unexpected history before start: %v", h)
+	}
+
+	for _, n := range []int{1000, 2000, 0} {
+		if _, err := w.Write(make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+		clk.Advance(time.Second)
+	}
+	clk.Advance(time.Millisecond * 500)
+	if _, err := w.Write(make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Millisecond * 250)
+
+	type sample struct {
+		off   time.Duration
+		dur   time.Duration
+		bytes infounit.ByteCount
+	}
+	check := func(want []sample) {
+		t.Helper()
+		h := w.History()
+		if len(h) != len(want) {
+			t.Fatalf("unexpected len: want: %d, got: %d: %v", len(want), len(h), h)
+		}
+		for i, s := range want {
+			br := infounit.BitRate(float64(s.bytes) * 8 / s.dur.Seconds())
+			if h[i].Start != t0.Add(s.off) || h[i].Duration != s.dur || h[i].Bytes != s.bytes || h[i].BitRate != br {
+				t.Errorf("#%d: unexpected sample: want: %v, got: %+v", i, s, h[i])
+			}
+		}
+	}
+	check([]sample{
+		{0, time.Second, 1000},
+		{time.Second, time.Second, 2000},
+		{time.Second * 2, time.Second, 0},
+		{time.Second * 3, time.Millisecond * 750, 500},
+	})
+
+	// Only the last 5s are retained, and the history is fixed at the close.
+	clk.Advance(time.Millisecond * 3750)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second * 10)
+	check([]sample{
+		{time.Second * 3, time.Second, 500},
+		{time.Second * 4, time.Second, 0},
+		{time.Second * 5, time.Second, 0},
+		{time.Second * 6, time.Second, 0},
+		{time.Second * 7, time.Millisecond * 500, 0},
+	})
+
+	conf.Retention = time.Second
+	if _, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, conf); !errors.Is(err, speedio.ErrInvalidParameter) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+//
+func TestMeterWriter_stats(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := &speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 2,
+		Clock:      clk,
+	}
+	w, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8, 16, 0, 32 kbit/s, and then 8 kbit/s for 500ms until the close.
+	for _, step := range []struct {
+		n       int
+		advance time.Duration
+	}{
+		{1000, time.Second},
+		{2000, time.Second * 2},
+		{4000, time.Second},
+		{500, time.Millisecond * 500},
+	} {
+		if _, err := w.Write(make([]byte, step.n)); err != nil {
+			t.Fatal(err)
+		}
+		clk.Advance(step.advance)
+	}
+	if st := w.Stats(); st.Samples != 4 || st.Peak != 32000 {
+		t.Errorf("unexpected stats before close: %+v", st)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second * 10)
+
+	st := w.Stats()
+	if st.Samples != 5 || st.Peak != 32000 || st.Min != 0 || st.Mean != 12800 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+	if math.Abs(float64(st.StdDev)-math.Sqrt(117.76e6)) > 1e-6 {
+		t.Errorf("unexpected standard deviation: %v", st.StdDev)
+	}
+	for _, p := range []struct {
+		name      string
+		got, want infounit.BitRate
+	}{
+		{"p50", st.P50, 8000},
+		{"p95", st.P95, 32000},
+		{"p99", st.P99, 32000},
+	} {
+		if math.Abs(float64(p.got-p.want)) > float64(p.want)*0.01 {
+			t.Errorf("unexpected %s: want: %v, got: %v", p.name, p.want, p.got)
+		}
+	}
+}
+
+//
+func TestMeterWriter_subscribe(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	conf := &speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 2,
+		Clock:      clk,
+	}
+	w, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan speedio.MeterSnapshot, 8)
+	w.Subscribe(ch)
+	w.Start()
+
+	check := func(off, dur time.Duration, bytes, total infounit.ByteCount, br infounit.BitRate) {
+		t.Helper()
+		var s speedio.MeterSnapshot
+		select {
+		case s = <-ch:
+		case <-time.After(time.Second * 5):
+			t.Fatal("no update")
+		}
+		if s.Start != t0.Add(off) || s.Duration != dur || s.Bytes != bytes || s.Total != total || s.Elapsed != off+dur || s.BitRate != br {
+			t.Errorf("unexpected snapshot: %+v", s)
+		}
+	}
+
+	clk.BlockUntil(1)
+	if _, err := w.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	check(0, time.Second, 1000, 1000, 8000)
+
+	// An idle period still produces an update.
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	check(time.Second, time.Second, 0, 1000, 4000)
+
+	// The last period is delivered at the close, and the updates stop.
+	clk.BlockUntil(1)
+	if _, err := w.Write(make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Millisecond * 500)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	check(time.Second*2, time.Millisecond*500, 500, 1500, 4000)
+	clk.Advance(time.Second * 5)
+	select {
+	case s := <-ch:
+		t.Errorf("unexpected update after close: %+v", s)
+	case <-time.After(time.Millisecond * 100):
+	}
+	if n := clk.Pending(); n != 0 {
+		t.Errorf("unexpected pending timers: %d", n)
+	}
+}
+
+// The snapshot of a period delivered late does not include the transfer after
+// the period, and cancelling the last registration stops the updates.
+func TestMeterWriter_onUpdate(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	conf := &speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 2,
+		Clock:      clk,
+	}
+	w, err := speedio.NewMeterWriterWithConfig(ioutil.Discard, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Start()
+	ch := make(chan speedio.MeterSnapshot)
+	gate := make(chan struct{})
+	cancel := w.OnUpdate(func(s speedio.MeterSnapshot) {
+		ch <- s
+		<-gate
+	})
+
+	clk.BlockUntil(1)
+	if _, err := w.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	if s := <-ch; s.Bytes != 1000 || s.Total != 1000 {
+		t.Errorf("unexpected snapshot: %+v", s)
+	}
+
+	// The goroutine is blocked in the callback while the transfers go on.
+	if _, err := w.Write(make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	if _, err := w.Write(make([]byte, 300)); err != nil {
+		t.Fatal(err)
+	}
+	gate <- struct{}{}
+	if s := <-ch; s.Start != t0.Add(time.Second) || s.Bytes != 500 || s.Total != 1500 || s.AverageBitRate != 6000 {
+		t.Errorf("unexpected snapshot: %+v", s)
+	}
+	gate <- struct{}{}
+
+	cancel()
+	cancel()
+	for i := 0; 0 < clk.Pending(); i++ {
+		if i == 100 {
+			t.Fatal("updates not stopped")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	clk.Advance(time.Second)
+	select {
+	case s := <-ch:
+		t.Errorf("unexpected update after cancel: %+v", s)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -5,6 +5,7 @@
 package speedio
 
 import (
+	"context"
 	"io"
 	"time"
 
@@ -44,11 +45,50 @@ func (w *Reader) LimitingBitRate() infounit.BitRate {
 	return w.lr.LimitingBitRate()
 }
 
+// ThrottledTime returns the total time waited for the limiting bit rate. The
+// time paused by Pause is not included.
+func (w *Reader) ThrottledTime() time.Duration {
+	return w.lr.ThrottledTime()
+}
+
 // SetBitRate sets a new bit rate limiting.
 func (w *Reader) SetBitRate(rate infounit.BitRate) error {
 	return w.lr.SetBitRate(rate)
 }
 
+// SetSchedule sets the schedule of the limiting bit rate. If s is nil, the
+// schedule is removed and the current bit rate is kept.
+func (w *Reader) SetSchedule(s *Schedule) error {
+	return w.lr.SetSchedule(s)
+}
+
+// SetTrace sets the bandwidth trace that drives the limiting bit rate. If loop
+// is true, the trace restarts from the beginning at its end. If t is nil, the
+// trace is removed and the current bit rate is kept.
+func (w *Reader) SetTrace(t *Trace, loop bool) error {
+	return w.lr.SetTrace(t, loop)
+}
+
+// Pause pauses the reader. While paused, Read blocks without consuming
+// tokens until Resume is called, the context is done, or the reader is closed.
+// The paused time is recorded by the meter.
+func (w *Reader) Pause() {
+	w.mr.met.pause(w.mr.clock.Now())
+	w.lr.Pause()
+}
+
+// Resume resumes the reader paused by Pause.
+func (w *Reader) Resume() {
+	w.lr.Resume()
+	w.mr.met.resume(w.mr.clock.Now())
+}
+
+// PausedTime returns the total time paused by Pause. If MeterConfig has
+// ExcludePaused set, it is excluded from the elapsed time returned by Total.
+func (w *Reader) PausedTime() time.Duration {
+	return w.mr.met.pausedDuration(w.mr.clock.Now())
+}
+
 // Close closes the reader.
 // If the underlying reader implements io.ReadCloser, its Close method
 // is also called.
@@ -92,6 +132,19 @@ func (w *Reader) Read(p []byte) (int, error) {
 	return w.lr.Read(p)
 }
 
+// ReadContext is the same as Read except that it stops waiting for the
+// limiter when ctx is done, and returns ctx.Err(). The reader remains usable.
+func (w *Reader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	return w.lr.ReadContext(ctx, p)
+}
+
+// TryRead is the same as Read except that it never blocks. If nothing is
+// allowed by the limiter, it returns 0, the duration until the tokens are
+// available, and ErrWouldBlock.
+func (w *Reader) TryRead(p []byte) (int, time.Duration, error) {
+	return w.lr.TryRead(p)
+}
+
 // Start starts the measurement. Calling this Start is optional, and normally it
 // is started automatically at the first read. This is used to adjust the
 // transfer start time for bit rate calculation.
@@ -111,6 +164,49 @@ func (w *Reader) BitRate() infounit.BitRate {
 	return w.mr.BitRate()
 }
 
+// EWMABitRates returns the exponentially weighted moving averages of the bit
+// rate for the half-lives in MeterConfig, in the same order. It returns nil if
+// no half-lives are configured.
+func (w *Reader) EWMABitRates() []infounit.BitRate {
+	return w.mr.EWMABitRates()
+}
+
+// History returns the samples of each resolution period retained by the
+// meter, in chronological order, ending now or at the close. The periods
+// without transfer are included as zero samples, and the last sample may be
+// shorter than the resolution. The length of the history is the Retention of
+// MeterConfig.
+func (w *Reader) History() []MeterSample {
+	return w.mr.History()
+}
+
+// Stats returns the statistics of the bit rates of the resolution periods in
+// the entire period from start, such as the peak, the minimum and the
+// percentiles. When it is called after being closed, it always returns the
+// same statistics from start to close.
+func (w *Reader) Stats() MeterStats {
+	return w.mr.Stats()
+}
+
+// OnUpdate registers f to be called each time a resolution period completes,
+// with the snapshot of the period, and returns the function to cancel it. f is
+// called even if nothing is transferred in the period, and once more for the
+// last period at Close, after which the updates stop. f is called from a
+// separate goroutine and should not block. The goroutine runs only while the
+// measurement is started and not closed, and while at least one registration
+// remains.
+func (w *Reader) OnUpdate(f func(MeterSnapshot)) func() {
+	return w.mr.OnUpdate(f)
+}
+
+// Subscribe registers ch to receive the snapshot each time a resolution period
+// completes, in the same way as OnUpdate, and returns the function to cancel
+// it. The snapshots are sent without blocking, and dropped if ch is not ready.
+// ch is not closed at Close.
+func (w *Reader) Subscribe(ch chan<- MeterSnapshot) func() {
+	return w.mr.Subscribe(ch)
+}
+
 // Total returns the data transfer amount, elapsed time, and bit rate in the
 // entire period from start. When it is called after being closed, it always
 // returns the same statistics from start to close.
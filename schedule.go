@@ -0,0 +1,151 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// Schedule indicates the limiting bit rate that changes depending on the day of
+// the week and the time of day. It can be attached to the limiters by their
+// SetSchedule methods.
+//
+// Rules are evaluated in order, and the bit rate of the first rule that matches
+// is used. If no rule matches, Default is used.
+//
+// Location is the time zone in which the rules are evaluated. If it is nil,
+// time.Local is used. The rules are evaluated in the wall clock time of the
+// location, so that the boundaries follow the daylight saving time changes.
+type Schedule struct {
+	Location *time.Location
+	Default  infounit.BitRate
+	Rules    []ScheduleRule
+}
+
+// ScheduleRule is a rule of the Schedule.
+//
+// Weekdays is the days of the week to which the rule applies. If it is empty,
+// the rule applies to every day.
+//
+// Start and End are the time of day, the durations since midnight in the wall
+// clock time, of the range to which the rule applies. Start is inclusive and
+// End is exclusive. If End is not after Start, the range continues past
+// midnight to End on the next day. For example, Start 22h and End 6h applies
+// from 22:00 on the specified days until 06:00 on the following days.
+type ScheduleRule struct {
+	Weekdays []time.Weekday
+	Start    time.Duration
+	End      time.Duration
+	BitRate  infounit.BitRate
+}
+
+// clone returns a copy of the schedule, so that the changes of the original
+// by the caller do not affect the limiter.
+func (s *Schedule) clone() *Schedule {
+	c := &Schedule{
+		Location: s.Location,
+		Default:  s.Default,
+		Rules:    make([]ScheduleRule, len(s.Rules)),
+	}
+	for i, r := range s.Rules {
+		c.Rules[i] = r
+		c.Rules[i].Weekdays = append([]time.Weekday(nil), r.Weekdays...)
+	}
+	if c.Location == nil {
+		c.Location = time.Local
+	}
+	return c
+}
+
+// check checks whether all the bit rates in the schedule can be limited with
+// the resolution and max-wait.
+func (s *Schedule) check(resolution, maxWait time.Duration) error {
+	if err := checkRate(s.Default, resolution, maxWait); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for i, r := range s.Rules {
+		switch {
+		case r.Start < 0 || time.Hour*24 < r.Start:
+			return fmt.Errorf("%w: rule #%d: start %s out of range", ErrInvalidParameter, i, r.Start)
+		case r.End < 0 || time.Hour*24 < r.End:
+			return fmt.Errorf("%w: rule #%d: end %s out of range", ErrInvalidParameter, i, r.End)
+		}
+		for _, wd := range r.Weekdays {
+			if wd < time.Sunday || time.Saturday < wd {
+				return fmt.Errorf("%w: rule #%d: invalid weekday %d", ErrInvalidParameter, i, wd)
+			}
+		}
+		if err := checkRate(r.BitRate, resolution, maxWait); err != nil {
+			return fmt.Errorf("rule #%d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// bitRateAt returns the bit rate at tc.
+func (s *Schedule) bitRateAt(tc time.Time) infounit.BitRate {
+	lt := tc.In(s.Location)
+	hh, mm, ss := lt.Clock()
+	tod := time.Duration(hh)*time.Hour + time.Duration(mm)*time.Minute + time.Duration(ss)*time.Second + time.Duration(lt.Nanosecond())
+	wd := lt.Weekday()
+	for _, r := range s.Rules {
+		if r.match(wd, tod) {
+			return r.BitRate
+		}
+	}
+	return s.Default
+}
+
+// nextChange returns the time of the earliest rule boundary after tc, when the
+// bit rate may change.
+func (s *Schedule) nextChange(tc time.Time) time.Time {
+	var next time.Time
+	lt := tc.In(s.Location)
+	y, m, d := lt.Date()
+	for i := 0; i < 2; i++ {
+		for _, r := range s.Rules {
+			for _, b := range []time.Duration{r.Start, r.End} {
+				bt := time.Date(
+					y, m, d+i,
+					int(b/time.Hour), int(b%time.Hour/time.Minute),
+					int(b%time.Minute/time.Second), int(b%time.Second),
+					s.Location,
+				)
+				if bt.After(tc) && (next.IsZero() || bt.Before(next)) {
+					next = bt
+				}
+			}
+		}
+		if !next.IsZero() {
+			break
+		}
+	}
+	return next
+}
+
+// match returns whether the rule applies to the time of day tod on the day of
+// the week wd.
+func (r *ScheduleRule) match(wd time.Weekday, tod time.Duration) bool {
+	if r.Start < r.End {
+		return r.onDay(wd) && r.Start <= tod && tod < r.End
+	}
+	return r.onDay(wd) && r.Start <= tod || r.onDay((wd+6)%7) && tod < r.End
+}
+
+// onDay returns whether the rule applies to the day of the week wd.
+func (r *ScheduleRule) onDay(wd time.Weekday) bool {
+	if len(r.Weekdays) == 0 {
+		return true
+	}
+	for _, w := range r.Weekdays {
+		if w == wd {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestSchedule_test1(t *testing.T) {
+	t.Parallel()
+
+	loc := time.FixedZone("JST", 9*60*60)
+	sched := &speedio.Schedule{
+		Location: loc,
+		Default:  500 * infounit.MegabitPerSecond,
+		Rules: []speedio.ScheduleRule{
+			{
+				Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+				Start:    time.Hour * 9,
+				End:      time.Hour * 17,
+				BitRate:  5 * infounit.MegabitPerSecond,
+			},
+			{
+				Weekdays: []time.Weekday{time.Friday},
+				Start:    time.Hour * 22,
+				End:      time.Hour * 2,
+				BitRate:  50 * infounit.MegabitPerSecond,
+			},
+		},
+	}
+
+	// 2021-07-02 is Friday.
+	clk := fakeclock.New(time.Date(2021, 7, 2, 8, 59, 59, 0, loc))
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	}
+	w, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, infounit.MegabitPerSecond, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetSchedule(sched); err != nil {
+		t.Fatal(err)
+	}
+	tcs := []struct {
+		tc   time.Time
+		rate infounit.BitRate
+	}{
+		{time.Date(2021, 7, 2, 8, 59, 59, 0, loc), 500 * infounit.MegabitPerSecond},
+		{time.Date(2021, 7, 2, 9, 0, 0, 0, loc), 5 * infounit.MegabitPerSecond},
+		{time.Date(2021, 7, 2, 16, 59, 59, 0, loc), 5 * infounit.MegabitPerSecond},
+		{time.Date(2021, 7, 2, 17, 0, 0, 0, loc), 500 * infounit.MegabitPerSecond},
+		{time.Date(2021, 7, 2, 22, 0, 0, 0, loc), 50 * infounit.MegabitPerSecond},
+		{time.Date(2021, 7, 3, 1, 59, 59, 0, loc), 50 * infounit.MegabitPerSecond},
+		{time.Date(2021, 7, 3, 2, 0, 0, 0, loc), 500 * infounit.MegabitPerSecond},
+		{time.Date(2021, 7, 3, 9, 30, 0, 0, loc), 500 * infounit.MegabitPerSecond},
+		{time.Date(2021, 7, 5, 9, 30, 0, 0, loc), 5 * infounit.MegabitPerSecond},
+	}
+	for _, tc := range tcs {
+		clk.Set(tc.tc)
+		if _, err := w.Write(make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.LimitingBitRate(); got != tc.rate {
+			t.Errorf("%s: unexpected bit rate: want: %v, got: %v", tc.tc, tc.rate, got)
+		}
+	}
+
+	if err := w.SetBitRate(infounit.MegabitPerSecond); err != nil {
+		t.Fatal(err)
+	}
+	clk.Set(time.Date(2021, 7, 5, 18, 0, 0, 0, loc))
+	if got := w.LimitingBitRate(); got != infounit.MegabitPerSecond {
+		t.Errorf("schedule not removed: %v", got)
+	}
+}
+
+//
+func TestSchedule_dst(t *testing.T) {
+	t.Parallel()
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	sched := &speedio.Schedule{
+		Location: loc,
+		Default:  500 * infounit.MegabitPerSecond,
+		Rules: []speedio.ScheduleRule{
+			{Start: time.Hour * 9, End: time.Hour * 17, BitRate: 5 * infounit.MegabitPerSecond},
+		},
+	}
+
+	// DST starts at 2021-03-14 02:00 in New York.
+	clk := fakeclock.New(time.Date(2021, 3, 13, 18, 0, 0, 0, loc))
+	g, err := speedio.NewLimiterGroupWithConfig(infounit.MegabitPerSecond, &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.SetSchedule(sched); err != nil {
+		t.Fatal(err)
+	}
+	clk.Set(time.Date(2021, 3, 14, 8, 59, 59, 0, loc))
+	if got := g.LimitingBitRate(); got != 500*infounit.MegabitPerSecond {
+		t.Errorf("unexpected bit rate: %v", got)
+	}
+	clk.Set(time.Date(2021, 3, 14, 9, 0, 0, 0, loc))
+	if got := g.LimitingBitRate(); got != 5*infounit.MegabitPerSecond {
+		t.Errorf("unexpected bit rate: %v", got)
+	}
+}
+
+//
+func TestSchedule_invalid(t *testing.T) {
+	t.Parallel()
+
+	w, err := speedio.NewLimiterWriter(ioutil.Discard, infounit.MegabitPerSecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheds := []*speedio.Schedule{
+		{Default: 0},
+		{Default: infounit.MegabitPerSecond, Rules: []speedio.ScheduleRule{{Start: -1, BitRate: infounit.MegabitPerSecond}}},
+		{Default: infounit.MegabitPerSecond, Rules: []speedio.ScheduleRule{{End: time.Hour * 25, BitRate: infounit.MegabitPerSecond}}},
+		{Default: infounit.MegabitPerSecond, Rules: []speedio.ScheduleRule{{BitRate: 1}}},
+	}
+	for i, s := range scheds {
+		if err := w.SetSchedule(s); !errors.Is(err, speedio.ErrInvalidParameter) {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// Trace is a recorded bandwidth trace, which drives the limiting bit rate over
+// time. It can be attached to the limiters by their SetTrace methods.
+//
+// Steps are the changes of the bit rate, sorted by their offsets from the
+// start of the trace. The first step must start at offset 0. A step may have a
+// zero bit rate, which means an outage, and the transfers wait until the next
+// step with a non-zero bit rate.
+//
+// Duration is the length of the trace. When the trace is looped, it restarts
+// from the first step at Duration. Otherwise, the bit rate of the last step
+// continues after the end of the trace.
+type Trace struct {
+	Steps    []TraceStep
+	Duration time.Duration
+}
+
+// TraceStep is a step of the Trace.
+type TraceStep struct {
+	Offset  time.Duration
+	BitRate infounit.BitRate
+}
+
+// MahimahiPacketSize is the size of a packet that can be delivered at each
+// delivery opportunity of a Mahimahi trace.
+const MahimahiPacketSize = 1500
+
+// DefaultTraceBin is the width of the time bins used to convert the delivery
+// opportunities of a Mahimahi trace into bit rates, if not specified.
+const DefaultTraceBin = time.Millisecond * 100
+
+// ParseMahimahiTrace parses a trace in the Mahimahi packet-delivery format,
+// where each line is a timestamp in milliseconds at which a packet of
+// MahimahiPacketSize bytes can be delivered. The delivery opportunities are
+// counted in time bins of width bin, and each bin is converted into a step of
+// the average bit rate in the bin. If bin is 0, DefaultTraceBin is used. The
+// Duration of the trace is the last timestamp, at which Mahimahi loops the
+// trace.
+func ParseMahimahiTrace(r io.Reader, bin time.Duration) (*Trace, error) {
+	switch {
+	case bin < 0:
+		return nil, fmt.Errorf("%w: negative bin %s", ErrInvalidParameter, bin)
+	case bin == 0:
+		bin = DefaultTraceBin
+	}
+	var ts []time.Duration
+	sc := bufio.NewScanner(r)
+	for ln := 1; sc.Scan(); ln++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ms, err := strconv.ParseUint(line, 10, 63)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid timestamp %q", ErrInvalidParameter, ln, line)
+		}
+		t := time.Duration(ms) * time.Millisecond
+		if 0 < len(ts) && t < ts[len(ts)-1] {
+			return nil, fmt.Errorf("%w: line %d: timestamp goes backwards", ErrInvalidParameter, ln)
+		}
+		ts = append(ts, t)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(ts) == 0 || ts[len(ts)-1] == 0 {
+		return nil, fmt.Errorf("%w: empty trace", ErrInvalidParameter)
+	}
+
+	tr := &Trace{Duration: ts[len(ts)-1]}
+	i := 0
+	for start := time.Duration(0); start < tr.Duration; start += bin {
+		end := start + bin
+		if tr.Duration < end {
+			end = tr.Duration
+		}
+		n := 0
+		for ; i < len(ts) && (ts[i] < end || end == tr.Duration); i++ {
+			n++
+		}
+		rate := infounit.BitRate(float64(n*MahimahiPacketSize) * bpscoef / float64(end-start))
+		if k := len(tr.Steps); 0 < k && tr.Steps[k-1].BitRate == rate {
+			continue
+		}
+		tr.Steps = append(tr.Steps, TraceStep{Offset: start, BitRate: rate})
+	}
+	return tr, nil
+}
+
+// ParseCSVTrace parses a trace in the CSV format, where each record is a pair
+// of a timestamp in seconds and a bit rate in bits per second, for example
+// "1.5,2000000". Empty lines and lines beginning with "#" are ignored, and the
+// first record is ignored as a header if it is not numeric. The timestamps must
+// start at 0 and must not decrease. The Duration of the trace is the timestamp
+// of the last record.
+func ParseCSVTrace(r io.Reader) (*Trace, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	tr := &Trace{}
+	for n := 1; ; n++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidParameter, err)
+		}
+		sec, err1 := strconv.ParseFloat(strings.TrimSpace(rec[0]), 64)
+		bps, err2 := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+		switch {
+		case n == 1 && (err1 != nil || err2 != nil):
+			continue // header
+		case err1 != nil || sec < 0 || math.IsInf(sec, 0) || math.IsNaN(sec):
+			return nil, fmt.Errorf("%w: record #%d: invalid timestamp %q", ErrInvalidParameter, n, rec[0])
+		case err2 != nil:
+			return nil, fmt.Errorf("%w: record #%d: invalid bit rate %q", ErrInvalidParameter, n, rec[1])
+		}
+		tr.Steps = append(tr.Steps, TraceStep{
+			Offset:  time.Duration(sec * float64(time.Second)),
+			BitRate: infounit.BitRate(bps),
+		})
+	}
+	if len(tr.Steps) == 0 {
+		return nil, fmt.Errorf("%w: empty trace", ErrInvalidParameter)
+	}
+	tr.Duration = tr.Steps[len(tr.Steps)-1].Offset
+	if err := tr.check(); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// clone returns a copy of the trace, so that the changes of the original by
+// the caller do not affect the limiter.
+func (t *Trace) clone() *Trace {
+	return &Trace{
+		Steps:    append([]TraceStep(nil), t.Steps...),
+		Duration: t.Duration,
+	}
+}
+
+// check checks whether the trace is valid.
+func (t *Trace) check() error {
+	switch {
+	case len(t.Steps) == 0:
+		return fmt.Errorf("%w: empty trace", ErrInvalidParameter)
+	case t.Steps[0].Offset != 0:
+		return fmt.Errorf("%w: first step at %s, not 0", ErrInvalidParameter, t.Steps[0].Offset)
+	case t.Duration < t.Steps[len(t.Steps)-1].Offset:
+		return fmt.Errorf("%w: trace duration %s before the last step", ErrInvalidParameter, t.Duration)
+	}
+	for i, s := range t.Steps {
+		switch {
+		case s.BitRate < 0 || math.IsInf(float64(s.BitRate), 0) || math.IsNaN(float64(s.BitRate)):
+			return fmt.Errorf("%w: step #%d: invalid bit rate %v", ErrInvalidParameter, i, s.BitRate)
+		case 0 < i && s.Offset < t.Steps[i-1].Offset:
+			return fmt.Errorf("%w: step #%d: offset goes backwards", ErrInvalidParameter, i)
+		}
+	}
+	return nil
+}
+
+// at returns the bit rate at the elapsed time et since the trace started, and
+// the elapsed time at which the bit rate changes next. If the bit rate never
+// changes, next is negative.
+func (t *Trace) at(et time.Duration, loop bool) (rate infounit.BitRate, next time.Duration) {
+	var base time.Duration
+	if loop && 0 < t.Duration {
+		base = et / t.Duration * t.Duration
+		et -= base
+	}
+	i := sort.Search(len(t.Steps), func(i int) bool { return et < t.Steps[i].Offset }) - 1
+	rate = t.Steps[i].BitRate
+	switch {
+	case i+1 < len(t.Steps):
+		return rate, base + t.Steps[i+1].Offset
+	case loop && 0 < t.Duration:
+		return rate, base + t.Duration
+	}
+	return rate, -1
+}
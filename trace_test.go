@@ -0,0 +1,246 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
+)
+
+//
+func TestParseMahimahiTrace(t *testing.T) {
+	t.Parallel()
+
+	// 3 packets in the first 100ms, and 2 packets in the last 100ms.
+	tr, err := speedio.ParseMahimahiTrace(strings.NewReader("1\n1\n50\n\n150\n200\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &speedio.Trace{
+		Steps: []speedio.TraceStep{
+			{Offset: 0, BitRate: 360000},
+			{Offset: time.Millisecond * 100, BitRate: 240000},
+		},
+		Duration: time.Millisecond * 200,
+	}
+	if !reflect.DeepEqual(tr, want) {
+		t.Errorf("unexpected trace: want: %+v, got: %+v", want, tr)
+	}
+
+	for _, s := range []string{"", "1\nx\n", "10\n5\n"} {
+		if _, err := speedio.ParseMahimahiTrace(strings.NewReader(s), 0); !errors.Is(err, speedio.ErrInvalidParameter) {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+	}
+}
+
+//
+func TestParseCSVTrace(t *testing.T) {
+	t.Parallel()
+
+	src := "time,bitrate\n0, 8000\n# outage\n1.5,0\n2,16000\n3,16000\n"
+	tr, err := speedio.ParseCSVTrace(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &speedio.Trace{
+		Steps: []speedio.TraceStep{
+			{Offset: 0, BitRate: 8000},
+			{Offset: time.Millisecond * 1500, BitRate: 0},
+			{Offset: time.Second * 2, BitRate: 16000},
+			{Offset: time.Second * 3, BitRate: 16000},
+		},
+		Duration: time.Second * 3,
+	}
+	if !reflect.DeepEqual(tr, want) {
+		t.Errorf("unexpected trace: want: %+v, got: %+v", want, tr)
+	}
+
+	for _, s := range []string{"", "0.5,8000\n", "0,8000\n1,-1\n", "0,8000\n2,1\n1,1\n", "0,8000,1\n"} {
+		if _, err := speedio.ParseCSVTrace(strings.NewReader(s)); !errors.Is(err, speedio.ErrInvalidParameter) {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+	}
+}
+
+//
+func TestLimiterWriter_trace(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	}
+	w, err := speedio.NewLimiterWriterWithConfig(ioutil.Discard, 80000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &speedio.Trace{
+		Steps: []speedio.TraceStep{
+			{Offset: 0, BitRate: 8000},
+			{Offset: time.Second, BitRate: 0},
+			{Offset: time.Second * 2, BitRate: 16000},
+		},
+		Duration: time.Second * 3,
+	}
+	if err := w.SetTrace(tr, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []infounit.BitRate{8000, 0, 16000, 8000, 0} {
+		if i != 0 {
+			clk.Advance(time.Second)
+		}
+		if rate := w.LimitingBitRate(); rate != want {
+			t.Errorf("%ds: unexpected rate: want: %v, got: %v", i, want, rate)
+		}
+	}
+
+	// At 4.5s, in the outage until 5s.
+	clk.Advance(time.Millisecond * 500)
+	n, wd, err := w.TryWrite(make([]byte, 100))
+	if n != 0 || wd != time.Millisecond*500 || !errors.Is(err, speedio.ErrWouldBlock) {
+		t.Errorf("unexpected result: want: 0, 500ms, %v, got: %d, %s, %v", speedio.ErrWouldBlock, n, wd, err)
+	}
+
+	// A blocking write waits until the outage ends.
+	done := make(chan error)
+	go func() {
+		_, err := w.Write(make([]byte, 100))
+		done <- err
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(time.Millisecond * 500)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if rate := w.LimitingBitRate(); rate != 16000 {
+		t.Errorf("unexpected rate: want: 16000, got: %v", rate)
+	}
+
+	// Removing the trace keeps the current bit rate.
+	if err := w.SetTrace(nil, false); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	if rate := w.LimitingBitRate(); rate != 16000 {
+		t.Errorf("unexpected rate: want: 16000, got: %v", rate)
+	}
+}
+
+// A reservation in an outage waits until the outage ends, without ending it
+// early for the other transfers.
+func TestLimiter_traceReserve(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	conf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	}
+	l, err := speedio.NewLimiterWithConfig(8000, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &speedio.Trace{
+		Steps: []speedio.TraceStep{
+			{Offset: 0, BitRate: 0},
+			{Offset: time.Second, BitRate: 0},
+			{Offset: time.Second * 2, BitRate: 8000},
+		},
+		Duration: time.Second * 2,
+	}
+	if err := l.SetTrace(tr, false); err != nil {
+		t.Fatal(err)
+	}
+
+	r := l.Reserve(500)
+	if d := r.Delay(); d != time.Second*2 {
+		t.Errorf("unexpected delay: want: 2s, got: %s", d)
+	}
+	if rate := l.LimitingBitRate(); rate != 0 {
+		t.Errorf("outage ended by the reservation: %v", rate)
+	}
+	if l.Allow(1) {
+		t.Error("allowed in the outage")
+	}
+	clk.Advance(time.Second * 2)
+	if d := r.Delay(); d != 0 {
+		t.Errorf("unexpected delay after the outage: %s", d)
+	}
+	if rate := l.LimitingBitRate(); rate != 8000 {
+		t.Errorf("unexpected rate after the outage: %v", rate)
+	}
+
+	// A trace without any non-zero bit rate never ends the outage.
+	tr.Steps = []speedio.TraceStep{{Offset: 0, BitRate: 0}}
+	if err := l.SetTrace(tr, true); err != nil {
+		t.Fatal(err)
+	}
+	if d := l.Reserve(1).Delay(); d != time.Duration(math.MaxInt64) {
+		t.Errorf("unexpected delay: %s", d)
+	}
+}
+
+// The reservations cancelled in an endless outage return only the tokens they
+// took, so they do not shorten the delay of the later reservations.
+func TestLimiter_traceOutageCancel(t *testing.T) {
+	t.Parallel()
+
+	for _, algo := range []speedio.Algorithm{speedio.TokenBucket, speedio.LeakyBucket, speedio.SlidingLog} {
+		delay := func(outage bool) time.Duration {
+			clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+			l, err := speedio.NewLimiterWithConfig(8000, &speedio.LimiterConfig{
+				Resolution: time.Second,
+				MaxWait:    time.Millisecond * 500,
+				Algorithm:  algo,
+				Clock:      clk,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 4; i++ {
+				if r := l.Reserve(500); !r.OK() {
+					t.Fatal("reservation not OK")
+				}
+			}
+			if outage {
+				tr := &speedio.Trace{Steps: []speedio.TraceStep{{Offset: 0, BitRate: 0}}}
+				if err := l.SetTrace(tr, false); err != nil {
+					t.Fatal(err)
+				}
+				for i := 0; i < 10; i++ {
+					if l.Allow(500) {
+						t.Error("allowed in the outage")
+					}
+				}
+				r := l.Reserve(500)
+				if d := r.Delay(); d != time.Duration(math.MaxInt64) {
+					t.Errorf("unexpected delay in the outage: %s", d)
+				}
+				r.Cancel()
+				if err := l.SetBitRate(8000); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return l.Reserve(500).Delay()
+		}
+		if want, got := delay(false), delay(true); got != want || got == 0 {
+			t.Errorf("unexpected delay after the outage: want: %s, got: %s", want, got)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/tunabay/go-infounit"
+)
+
+// Transport implements an http.RoundTripper that limits and measures the
+// request bodies and the response bodies. The limits are chosen per host, and
+// all the requests to the same host share the limits. The bit rates are
+// measured per host and in total.
+//
+// The host is the Host field of the request URL, which may include the port.
+// The zero value is ready to use, and it works the same as Base without any
+// limits.
+type Transport struct {
+	// Base is the underlying RoundTripper. If nil, http.DefaultTransport
+	// is used.
+	Base http.RoundTripper
+
+	// BitRate returns the limiting bit rates of the request bodies and the
+	// response bodies for the host. It is called when the first request to
+	// the host is made, or more than once if the first requests are made
+	// concurrently, in which case only one of the results is used. A zero
+	// bit rate means no limit. If nil, no limits are applied.
+	BitRate func(host string) (request, response infounit.BitRate)
+
+	// LimiterConfig and MeterConfig are the configurations used for the
+	// limiters and the meters. If nil, the default configurations will be
+	// used.
+	LimiterConfig *LimiterConfig
+	MeterConfig   *MeterConfig
+
+	once     sync.Once
+	initErr  error
+	req, res *MeterGroup
+	hosts    map[string]*transportHost
+	mu       sync.Mutex
+}
+
+// transportHost holds the limiters and the meters of a host.
+type transportHost struct {
+	reqLim, resLim *LimiterGroup // nil if not limited
+	reqMet, resMet *MeterGroup
+}
+
+// init creates the meters for the total.
+func (t *Transport) init() {
+	t.hosts = make(map[string]*transportHost)
+	if t.req, t.initErr = NewMeterGroupWithConfig(t.MeterConfig); t.initErr != nil {
+		return
+	}
+	t.res, t.initErr = NewMeterGroupWithConfig(t.MeterConfig)
+}
+
+// host returns the limiters and the meters of the host, creating them at the
+// first request to the host.
+func (t *Transport) host(name string) (*transportHost, error) {
+	t.once.Do(t.init)
+	if t.initErr != nil {
+		return nil, t.initErr
+	}
+
+	t.mu.Lock()
+	h, ok := t.hosts[name]
+	t.mu.Unlock()
+	if ok {
+		return h, nil
+	}
+
+	// The callback is called without the lock, and the limiters are created
+	// before the meters so that nothing is left behind on failure.
+	h = &transportHost{}
+	if t.BitRate != nil {
+		reqRate, resRate := t.BitRate(name)
+		var err error
+		if reqRate != 0 {
+			if h.reqLim, err = NewLimiterGroupWithConfig(reqRate, t.LimiterConfig); err != nil {
+				return nil, err
+			}
+		}
+		if resRate != 0 {
+			if h.resLim, err = NewLimiterGroupWithConfig(resRate, t.LimiterConfig); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if h, ok := t.hosts[name]; ok {
+		return h, nil // created by a concurrent request
+	}
+	h.reqMet, h.resMet = t.req.NewGroup(), t.res.NewGroup()
+	t.hosts[name] = h
+	return h, nil
+}
+
+// RoundTrip executes a single HTTP transaction with the underlying
+// RoundTripper, limiting and measuring the request body and the response
+// body. Closing the response body also closes the original response body.
+// The body of a 101 Switching Protocols response is returned as is, since it
+// is the writable connection of the new protocol.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h, err := t.host(req.URL.Host)
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	ctx := req.Context()
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(ctx)
+		req.Body = wrapBody(ctx, req.Body, h.reqMet, h.reqLim)
+		if getBody := req.GetBody; getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return wrapBody(ctx, body, h.reqMet, h.reqLim), nil
+			}
+		}
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// The body of a protocol switch is the connection, which is not wrapped
+	// to keep it writable.
+	if res.Body != nil && res.Body != http.NoBody && res.StatusCode != http.StatusSwitchingProtocols {
+		res.Body = wrapBody(ctx, res.Body, h.resMet, h.resLim)
+	}
+	return res, nil
+}
+
+// wrapBody wraps body with a meter of the group met, and with a limiter of the
+// group lim if it is not nil.
+func wrapBody(ctx context.Context, body io.ReadCloser, met *MeterGroup, lim *LimiterGroup) io.ReadCloser {
+	mr := met.NewReader(body)
+	if lim == nil {
+		return mr
+	}
+	return &transportBody{ctx: ctx, rd: &Reader{mr: mr, lr: lim.NewReader(mr)}}
+}
+
+// transportBody is a limited body that stops waiting for the limiter when the
+// context of the request is done.
+type transportBody struct {
+	ctx context.Context
+	rd  *Reader
+}
+
+// Read reads data from the body.
+func (b *transportBody) Read(p []byte) (int, error) {
+	return b.rd.ReadContext(b.ctx, p)
+}
+
+// Close closes the body, and also the original body.
+func (b *transportBody) Close() error {
+	return b.rd.Close()
+}
+
+// CloseIdleConnections calls CloseIdleConnections of the underlying
+// RoundTripper if it implements the method.
+func (t *Transport) CloseIdleConnections() {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if ci, ok := base.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// Meter returns the MeterGroups measuring the request bodies and the response
+// bodies of all the hosts. It returns nils if the configuration is invalid.
+func (t *Transport) Meter() (request, response *MeterGroup) {
+	t.once.Do(t.init)
+	return t.req, t.res
+}
+
+// HostMeter returns the MeterGroups measuring the request bodies and the
+// response bodies of the host. It returns nils if no request has been made to
+// the host.
+func (t *Transport) HostMeter(host string) (request, response *MeterGroup) {
+	t.once.Do(t.init)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.hosts[host]
+	if !ok {
+		return nil, nil
+	}
+	return h.reqMet, h.resMet
+}
+
+// Hosts returns the hosts to which requests have been made, in sorted order.
+func (t *Transport) Hosts() []string {
+	t.once.Do(t.init)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hosts := make([]string, 0, len(t.hosts))
+	for h := range t.hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2021 Hirotsuna Mizuno. All rights reserved.
+// Use of this source code is governed by the MIT license that can be found in
+// the LICENSE file.
+
+package speedio_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/tunabay/go-infounit"
+	"github.com/tunabay/go-speedio"
+)
+
+//
+func TestTransport_test1(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		if n != 5000 {
+			t.Errorf("unexpected request body len: want: 5000, got: %d", n)
+		}
+		_, _ = w.Write(make([]byte, 20000))
+	}))
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &speedio.Transport{
+		BitRate: func(host string) (infounit.BitRate, infounit.BitRate) {
+			if host != srvURL.Host {
+				t.Errorf("unexpected host: %s", host)
+			}
+			return 0, 80000
+		},
+	}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr}
+
+	// 20000 bytes at 80 kbit/s: 10000 bytes burst and 10000 bytes in the
+	// next 1s.
+	tc := time.Now()
+	res, err := client.Post(srv.URL, "application/octet-stream", bytes.NewReader(make([]byte, 5000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(ioutil.Discard, res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 20000 {
+		t.Errorf("unexpected response body len: want: 20000, got: %d", n)
+	}
+	if et := time.Since(tc); et < time.Millisecond*900 {
+		t.Errorf("too fast: %s", et)
+	}
+
+	if hosts := tr.Hosts(); len(hosts) != 1 || hosts[0] != srvURL.Host {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+	hreq, hres := tr.HostMeter(srvURL.Host)
+	if bc, _, _ := hreq.Total(); bc != 5000 {
+		t.Errorf("unexpected host request total: %v", bc)
+	}
+	if bc, _, _ := hres.Total(); bc != 20000 {
+		t.Errorf("unexpected host response total: %v", bc)
+	}
+	_, gres := tr.Meter()
+	if bc, _, _ := gres.Total(); bc != 20000 {
+		t.Errorf("unexpected response total: %v", bc)
+	}
+	if req, res := tr.HostMeter("unknown"); req != nil || res != nil {
+		t.Error("unexpected meters for unknown host")
+	}
+}
+
+// The callback may use the Transport, and a failure leaves no host behind.
+func TestTransport_bitRateCallback(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 100))
+	}))
+	defer srv.Close()
+
+	tr := &speedio.Transport{}
+	tr.BitRate = func(host string) (infounit.BitRate, infounit.BitRate) {
+		_ = tr.Hosts()
+		_, _ = tr.HostMeter(host)
+		return 0, 0.001 // too small
+	}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr}
+
+	for i := 0; i < 2; i++ {
+		done := make(chan error)
+		go func() {
+			_, err := client.Get(srv.URL)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, speedio.ErrInvalidParameter) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("deadlock")
+		}
+	}
+	if hosts := tr.Hosts(); len(hosts) != 0 {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+// The body of a protocol switch remains writable.
+func TestTransport_switchingProtocols(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = brw.Flush()
+		_, _ = io.Copy(conn, brw)
+	}))
+	defer srv.Close()
+
+	tr := &speedio.Transport{
+		BitRate: func(string) (infounit.BitRate, infounit.BitRate) { return 80000, 80000 },
+	}
+	defer tr.CloseIdleConnections()
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status: %s", res.Status)
+	}
+	rwc, ok := res.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("body is not writable: %T", res.Body)
+	}
+	if _, err := rwc.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(rwc, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("unexpected echo: %q, %v", buf, err)
+	}
+}
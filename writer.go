@@ -5,6 +5,7 @@
 package speedio
 
 import (
+	"context"
 	"io"
 	"time"
 
@@ -44,11 +45,56 @@ func (w *Writer) LimitingBitRate() infounit.BitRate {
 	return w.lw.LimitingBitRate()
 }
 
+// ThrottledTime returns the total time waited for the limiting bit rate. The
+// time paused by Pause is not included.
+func (w *Writer) ThrottledTime() time.Duration {
+	return w.lw.ThrottledTime()
+}
+
 // SetBitRate sets a new bit rate limiting.
 func (w *Writer) SetBitRate(rate infounit.BitRate) error {
 	return w.lw.SetBitRate(rate)
 }
 
+// SetSchedule sets the schedule of the limiting bit rate. If s is nil, the
+// schedule is removed and the current bit rate is kept.
+func (w *Writer) SetSchedule(s *Schedule) error {
+	return w.lw.SetSchedule(s)
+}
+
+// SetTrace sets the bandwidth trace that drives the limiting bit rate. If loop
+// is true, the trace restarts from the beginning at its end. If t is nil, the
+// trace is removed and the current bit rate is kept.
+func (w *Writer) SetTrace(t *Trace, loop bool) error {
+	return w.lw.SetTrace(t, loop)
+}
+
+// Pause pauses the writer. While paused, Write blocks without consuming
+// tokens until Resume is called, the context is done, or the writer is closed.
+// The paused time is recorded by the meter.
+func (w *Writer) Pause() {
+	w.mw.met.pause(w.mw.clock.Now())
+	w.lw.Pause()
+}
+
+// Resume resumes the writer paused by Pause.
+func (w *Writer) Resume() {
+	w.lw.Resume()
+	w.mw.met.resume(w.mw.clock.Now())
+}
+
+// SetAdaptive enables the adaptive rate control with the configuration. If
+// conf is nil, the control is disabled and the current bit rate is kept.
+func (w *Writer) SetAdaptive(conf *AdaptiveConfig) error {
+	return w.lw.SetAdaptive(conf)
+}
+
+// PausedTime returns the total time paused by Pause. If MeterConfig has
+// ExcludePaused set, it is excluded from the elapsed time returned by Total.
+func (w *Writer) PausedTime() time.Duration {
+	return w.mw.met.pausedDuration(w.mw.clock.Now())
+}
+
 // Close closes the writer.
 // If the underlying writer implements io.WriteCloser, its Close method
 // is also called.
@@ -92,6 +138,20 @@ func (w *Writer) Write(p []byte) (int, error) {
 	return w.lw.Write(p)
 }
 
+// WriteContext is the same as Write except that it stops waiting for the
+// limiter when ctx is done, and returns the number of bytes already written
+// and ctx.Err(). The writer remains usable.
+func (w *Writer) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return w.lw.WriteContext(ctx, p)
+}
+
+// TryWrite is the same as Write except that it never blocks. If nothing is
+// allowed by the limiter, it returns 0, the duration until the tokens are
+// available, and ErrWouldBlock.
+func (w *Writer) TryWrite(p []byte) (int, time.Duration, error) {
+	return w.lw.TryWrite(p)
+}
+
 // Start starts the measurement. Calling this Start is optional, and normally it
 // is started automatically at the first write. This is used to adjust the
 // transfer start time for bit rate calculation.
@@ -111,6 +171,49 @@ func (w *Writer) BitRate() infounit.BitRate {
 	return w.mw.BitRate()
 }
 
+// EWMABitRates returns the exponentially weighted moving averages of the bit
+// rate for the half-lives in MeterConfig, in the same order. It returns nil if
+// no half-lives are configured.
+func (w *Writer) EWMABitRates() []infounit.BitRate {
+	return w.mw.EWMABitRates()
+}
+
+// History returns the samples of each resolution period retained by the
+// meter, in chronological order, ending now or at the close. The periods
+// without transfer are included as zero samples, and the last sample may be
+// shorter than the resolution. The length of the history is the Retention of
+// MeterConfig.
+func (w *Writer) History() []MeterSample {
+	return w.mw.History()
+}
+
+// Stats returns the statistics of the bit rates of the resolution periods in
+// the entire period from start, such as the peak, the minimum and the
+// percentiles. When it is called after being closed, it always returns the
+// same statistics from start to close.
+func (w *Writer) Stats() MeterStats {
+	return w.mw.Stats()
+}
+
+// OnUpdate registers f to be called each time a resolution period completes,
+// with the snapshot of the period, and returns the function to cancel it. f is
+// called even if nothing is transferred in the period, and once more for the
+// last period at Close, after which the updates stop. f is called from a
+// separate goroutine and should not block. The goroutine runs only while the
+// measurement is started and not closed, and while at least one registration
+// remains.
+func (w *Writer) OnUpdate(f func(MeterSnapshot)) func() {
+	return w.mw.OnUpdate(f)
+}
+
+// Subscribe registers ch to receive the snapshot each time a resolution period
+// completes, in the same way as OnUpdate, and returns the function to cancel
+// it. The snapshots are sent without blocking, and dropped if ch is not ready.
+// ch is not closed at Close.
+func (w *Writer) Subscribe(ch chan<- MeterSnapshot) func() {
+	return w.mw.Subscribe(ch)
+}
+
 // Total returns the data transfer amount, elapsed time, and bit rate in the
 // entire period from start. When it is called after being closed, it always
 // returns the same statistics from start to close.
@@ -11,6 +11,7 @@ import (
 
 	"github.com/tunabay/go-randdata"
 	"github.com/tunabay/go-speedio"
+	"github.com/tunabay/go-speedio/fakeclock"
 )
 
 //
@@ -55,3 +56,94 @@ func TestWriter_test1(t *testing.T) {
 	bc, et, br := w.Total()
 	t.Logf("total(n=%d): %v, %v, %v", n, bc, et, br)
 }
+
+//
+func TestWriter_pause(t *testing.T) {
+	t.Parallel()
+
+	t0 := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	clk := fakeclock.New(t0)
+	lconf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Millisecond * 500,
+		Clock:      clk,
+	}
+	mconf := &speedio.MeterConfig{
+		Resolution:    time.Millisecond * 500,
+		Sample:        time.Second * 3,
+		Clock:         clk,
+		ExcludePaused: true,
+	}
+	w, err := speedio.NewWriterWithConfig(ioutil.Discard, 8000, lconf, mconf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	w.Pause()
+	clk.Advance(time.Second * 3)
+	w.Resume()
+	clk.Advance(time.Second)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if pt := w.PausedTime(); pt != time.Second*3 {
+		t.Errorf("unexpected paused time: want: 3s, got: %s", pt)
+	}
+	bc, et, _ := w.Total()
+	if bc != 1000 || et != time.Second*2 {
+		t.Errorf("unexpected total: want: 1000 B, 2s, got: %v, %s", bc, et)
+	}
+}
+
+// A write waiting for the limiter does not transfer when it is paused in the
+// meantime.
+func TestWriter_pauseWhileWaiting(t *testing.T) {
+	t.Parallel()
+
+	clk := fakeclock.New(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC))
+	lconf := &speedio.LimiterConfig{
+		Resolution: time.Second,
+		MaxWait:    time.Second,
+		Clock:      clk,
+	}
+	mconf := &speedio.MeterConfig{
+		Resolution: time.Second,
+		Sample:     time.Second * 3,
+		Clock:      clk,
+	}
+	w, err := speedio.NewWriterWithConfig(ioutil.Discard, 8000, lconf, mconf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 2000))
+		done <- err
+	}()
+	clk.BlockUntil(1) // 1000 bytes burst, and waiting for the rest
+	w.Pause()
+	clk.Advance(time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("not paused: %v", err)
+	default:
+	}
+	if bc, _, _ := w.Total(); bc != 1000 {
+		t.Errorf("transferred while paused: %v", bc)
+	}
+
+	w.Resume()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if bc, _, _ := w.Total(); bc != 2000 {
+		t.Errorf("unexpected total: %v", bc)
+	}
+}